@@ -25,6 +25,8 @@ type Definition struct {
 	Type DataType `json:"type,omitempty"`
 	// Description is the description of the schema.
 	Description string `json:"description,omitempty"`
+	// Format is the format of a string, e.g. "date-time", if the schema type is String.
+	Format string `json:"format,omitempty"`
 	// Enum is used to restrict a value to a fixed set of values. It must be an array with at least
 	// one element, where each element is unique. You will probably only use this with strings.
 	Enum []string `json:"enum,omitempty"`
@@ -34,6 +36,9 @@ type Definition struct {
 	Required []string `json:"required,omitempty"`
 	// Items specifies which data type an array contains, if the schema type is Array.
 	Items *Definition `json:"items,omitempty"`
+	// PropertyOrdering specifies the order in which the properties of an object should be
+	// generated, if the schema type is Object. It is a Gemini extension to JSON Schema.
+	PropertyOrdering []string `json:"propertyOrdering,omitempty"`
 }
 
 func (d Definition) MarshalJSON() ([]byte, error) {
@@ -2,6 +2,7 @@ package llms
 
 import (
 	"encoding/json"
+	"io"
 
 	"github.com/tmc/langchaingo/schema"
 )
@@ -61,6 +62,16 @@ type BinaryContent struct {
 
 func (BinaryContent) isPart() {}
 
+// ReaderContent is content holding binary data with a MIME type that is read
+// from Reader when the content is sent, e.g. an image streamed from a file or
+// a network response.
+type ReaderContent struct {
+	MIMEType string
+	Reader   io.Reader
+}
+
+func (ReaderContent) isPart() {}
+
 // ContentResponse is the response returned by a GenerateContent call.
 // It can potentially return multiple response choices.
 type ContentResponse struct {
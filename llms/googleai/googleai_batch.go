@@ -0,0 +1,68 @@
+package googleai
+
+import (
+	"context"
+	"sync"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+// defaultBatchConcurrency is the number of prompts GenerateBatch generates
+// content for at a time, unless set with WithBatchConcurrency.
+const defaultBatchConcurrency = 4
+
+// batchConcurrencyMetadataKey is the llms.CallOptions metadata key under which
+// WithBatchConcurrency stores the concurrency limit.
+const batchConcurrencyMetadataKey = "googleai.batch_concurrency"
+
+// PromptRequest is a single prompt of a GenerateBatch call.
+type PromptRequest struct {
+	// Messages are the messages to generate content from, as for
+	// GenerateContent.
+	Messages []llms.MessageContent
+	// Options are call options for this prompt only, applied after the
+	// options of the batch.
+	Options []llms.CallOption
+}
+
+// WithBatchConcurrency sets the number of prompts GenerateBatch generates
+// content for at a time. It defaults to 4. The client's limit set with
+// WithMaxConcurrentRequests still applies.
+func WithBatchConcurrency(n int) llms.CallOption {
+	return llms.WithMetadata(batchConcurrencyMetadataKey, n)
+}
+
+// GenerateBatch generates content for independent prompts concurrently, e.g.
+// for evaluations, with options applied to every prompt. It returns the
+// response and error of every prompt, in order, as GenerateContent returns
+// them. A streaming function set in the options is called concurrently for
+// different prompts.
+//
+// Once ctx is done, the prompts not started yet fail with ctx.Err().
+func (g *GoogleAI) GenerateBatch(ctx context.Context, prompts []PromptRequest, options ...llms.CallOption) ([]*llms.ContentResponse, []error) {
+	concurrency := defaultBatchConcurrency
+	if n, ok := g.callOptions(options...).Metadata[batchConcurrencyMetadataKey].(int); ok && n > 0 {
+		concurrency = n
+	}
+
+	responses := make([]*llms.ContentResponse, len(prompts))
+	errs := make([]error, len(prompts))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, prompt := range prompts {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			errs[i] = ctx.Err()
+			continue
+		}
+		wg.Add(1)
+		go func(i int, prompt PromptRequest) {
+			defer func() { <-sem; wg.Done() }()
+			promptOptions := append(append([]llms.CallOption{}, options...), prompt.Options...)
+			responses[i], errs[i] = g.GenerateContent(ctx, prompt.Messages, promptOptions...)
+		}(i, prompt)
+	}
+	wg.Wait()
+	return responses, errs
+}
@@ -0,0 +1,84 @@
+package googleai
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tmc/langchaingo/llms"
+	"github.com/tmc/langchaingo/schema"
+)
+
+func TestGenerateBatch(t *testing.T) {
+	t.Parallel()
+
+	// The server echoes the prompt, and records how many requests are in
+	// flight at most.
+	var mu sync.Mutex
+	var inFlight, maxInFlight int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inFlight++
+		maxInFlight = max(maxInFlight, inFlight)
+		mu.Unlock()
+		defer func() {
+			mu.Lock()
+			inFlight--
+			mu.Unlock()
+		}()
+
+		var req struct {
+			Contents []struct {
+				Parts []struct {
+					Text string `json:"text"`
+				} `json:"parts"`
+			} `json:"contents"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		time.Sleep(20 * time.Millisecond)
+
+		text, _ := json.Marshal(req.Contents[0].Parts[0].Text)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"candidates": [{"content": {"role": "model", "parts": [{"text": ` + string(text) + `}]}, "finishReason": "STOP"}]}`))
+	}))
+	defer srv.Close()
+
+	prompt := func(text string) PromptRequest {
+		return PromptRequest{Messages: []llms.MessageContent{{
+			Role:  schema.ChatMessageTypeHuman,
+			Parts: []llms.ContentPart{llms.TextContent{Text: text}},
+		}}}
+	}
+	prompts := []PromptRequest{prompt("a"), prompt("b"), {}, prompt("c"), prompt("d"), prompt("e")}
+
+	g := newFakeAPIClient(t, srv.URL)
+	responses, errs := g.GenerateBatch(context.Background(), prompts, WithBatchConcurrency(2))
+	require.Len(t, responses, len(prompts))
+	require.Len(t, errs, len(prompts))
+	for i, want := range []string{"a", "b", "", "c", "d", "e"} {
+		if want == "" {
+			require.ErrorIs(t, errs[i], ErrNoMessages)
+			assert.Nil(t, responses[i])
+			continue
+		}
+		require.NoError(t, errs[i])
+		assert.Equal(t, want, responses[i].Choices[0].Content)
+	}
+	mu.Lock()
+	assert.Equal(t, 2, maxInFlight)
+	mu.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	responses, errs = g.GenerateBatch(ctx, prompts[:2])
+	for i := range errs {
+		require.ErrorIs(t, errs[i], context.Canceled)
+		assert.Nil(t, responses[i])
+	}
+}
@@ -0,0 +1,139 @@
+package googleai
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+// ErrCircuitOpen is returned without calling the API while the circuit
+// breaker configured with WithCircuitBreaker is open.
+var ErrCircuitOpen = errors.New("circuit breaker is open after repeated failures")
+
+// CircuitState is the state of the circuit breaker configured with
+// WithCircuitBreaker.
+type CircuitState int
+
+const (
+	// CircuitClosed lets all calls through; this is the normal state.
+	CircuitClosed CircuitState = iota
+	// CircuitOpen fails all calls fast with ErrCircuitOpen.
+	CircuitOpen
+	// CircuitHalfOpen lets a single trial call through after the cooldown. If
+	// it succeeds the circuit closes, otherwise it opens again.
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// circuitBreaker trips after a number of consecutive API failures. A nil
+// *circuitBreaker is disabled and lets all calls through.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mu       sync.Mutex
+	state    CircuitState
+	failures int
+	openedAt time.Time
+	trialing bool
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	if threshold <= 0 {
+		return nil
+	}
+	return &circuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		now:       time.Now,
+	}
+}
+
+// currentState returns the state of the breaker, moving it from open to
+// half-open once the cooldown has passed. cb.mu must be held.
+func (cb *circuitBreaker) currentState() CircuitState {
+	if cb.state == CircuitOpen && cb.now().Sub(cb.openedAt) >= cb.cooldown {
+		cb.state = CircuitHalfOpen
+	}
+	return cb.state
+}
+
+// allow reports whether an API call may proceed. Every allowed call must be
+// followed by a call to record with its result.
+func (cb *circuitBreaker) allow() error {
+	if cb == nil {
+		return nil
+	}
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	switch cb.currentState() {
+	case CircuitOpen:
+		return ErrCircuitOpen
+	case CircuitHalfOpen:
+		if cb.trialing {
+			return ErrCircuitOpen
+		}
+		cb.trialing = true
+	case CircuitClosed:
+	}
+	return nil
+}
+
+// record records the result of an API call allowed by allow. Errors caused by
+// the caller, like cancellation or blocked content, say nothing about the
+// health of the API: they are neither failures nor successes, and only free
+// the trial call of a half-open breaker.
+func (cb *circuitBreaker) record(err error) {
+	if cb == nil {
+		return
+	}
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.trialing = false
+
+	var blockedErr *genai.BlockedError
+	if errors.Is(err, context.Canceled) || errors.As(err, &blockedErr) {
+		return
+	}
+	if err == nil {
+		cb.state = CircuitClosed
+		cb.failures = 0
+		return
+	}
+
+	cb.failures++
+	if cb.state == CircuitHalfOpen || cb.failures >= cb.threshold {
+		cb.state = CircuitOpen
+		cb.openedAt = cb.now()
+	}
+}
+
+// CircuitState returns the current state of the circuit breaker configured
+// with WithCircuitBreaker, e.g. for metrics. Without a circuit breaker it is
+// always CircuitClosed.
+func (g *GoogleAI) CircuitState() CircuitState {
+	if g.breaker == nil {
+		return CircuitClosed
+	}
+	g.breaker.mu.Lock()
+	defer g.breaker.mu.Unlock()
+	return g.breaker.currentState()
+}
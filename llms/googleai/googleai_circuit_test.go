@@ -0,0 +1,75 @@
+package googleai
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	cb := newCircuitBreaker(2, time.Minute)
+	cb.now = func() time.Time { return now }
+	g := &GoogleAI{breaker: cb}
+	errAPI := errors.New("api error")
+
+	// Failures that aren't consecutive don't trip the breaker.
+	require.NoError(t, cb.allow())
+	cb.record(errAPI)
+	require.NoError(t, cb.allow())
+	cb.record(nil)
+	require.NoError(t, cb.allow())
+	cb.record(errAPI)
+	assert.Equal(t, CircuitClosed, g.CircuitState())
+
+	// Errors caused by the caller are neither failures nor successes, so
+	// they don't reset the count of consecutive failures.
+	require.NoError(t, cb.allow())
+	cb.record(&genai.BlockedError{})
+	require.NoError(t, cb.allow())
+	cb.record(context.Canceled)
+	assert.Equal(t, CircuitClosed, g.CircuitState())
+
+	require.NoError(t, cb.allow())
+	cb.record(errAPI)
+	assert.Equal(t, CircuitOpen, g.CircuitState())
+	require.ErrorIs(t, cb.allow(), ErrCircuitOpen)
+
+	// After the cooldown, a single trial call is let through.
+	now = now.Add(time.Minute)
+	assert.Equal(t, CircuitHalfOpen, g.CircuitState())
+	require.NoError(t, cb.allow())
+	require.ErrorIs(t, cb.allow(), ErrCircuitOpen)
+
+	// A canceled trial call frees the trial without closing the breaker.
+	cb.record(context.Canceled)
+	assert.Equal(t, CircuitHalfOpen, g.CircuitState())
+	require.NoError(t, cb.allow())
+	cb.record(errAPI)
+	assert.Equal(t, CircuitOpen, g.CircuitState())
+
+	now = now.Add(time.Minute)
+	require.NoError(t, cb.allow())
+	cb.record(nil)
+	assert.Equal(t, CircuitClosed, g.CircuitState())
+	require.NoError(t, cb.allow())
+}
+
+func TestCircuitBreakerDisabled(t *testing.T) {
+	t.Parallel()
+
+	cb := newCircuitBreaker(0, time.Minute)
+	assert.Nil(t, cb)
+	for i := 0; i < 10; i++ {
+		require.NoError(t, cb.allow())
+		cb.record(errors.New("api error"))
+	}
+	assert.Equal(t, CircuitClosed, (&GoogleAI{}).CircuitState())
+}
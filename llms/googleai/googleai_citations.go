@@ -0,0 +1,118 @@
+package googleai
+
+import (
+	"sort"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+// CitationSegment is a contiguous piece of a response's content, together
+// with the citation sources that cover it.
+type CitationSegment struct {
+	// Text is the segment's text.
+	Text string
+	// SourceIndices are indices into the candidate's
+	// CitationMetadata.CitationSources of the sources covering this segment.
+	// It is empty for uncited text.
+	SourceIndices []int
+}
+
+// citationSegments splits content into segments at the boundaries of the
+// citation sources in metadata. Source indices are byte offsets into content,
+// the candidate's text parts joined without separator, as in the response's
+// content; spans outside content are clipped.
+func citationSegments(content string, metadata *genai.CitationMetadata) []CitationSegment {
+	if content == "" {
+		return nil
+	}
+
+	var sources []*genai.CitationSource
+	if metadata != nil {
+		sources = metadata.CitationSources
+	}
+
+	clip := func(i int) int {
+		return max(0, min(i, len(content)))
+	}
+	span := func(source *genai.CitationSource) (int, int) {
+		start, end := 0, len(content)
+		if source.StartIndex != nil {
+			start = clip(int(*source.StartIndex))
+		}
+		if source.EndIndex != nil {
+			end = clip(int(*source.EndIndex))
+		}
+		return start, end
+	}
+
+	boundaries := map[int]bool{0: true, len(content): true}
+	for _, source := range sources {
+		start, end := span(source)
+		boundaries[start] = true
+		boundaries[end] = true
+	}
+	offsets := make([]int, 0, len(boundaries))
+	for offset := range boundaries {
+		offsets = append(offsets, offset)
+	}
+	sort.Ints(offsets)
+
+	segments := make([]CitationSegment, 0, len(offsets)-1)
+	for i := 0; i < len(offsets)-1; i++ {
+		segment := CitationSegment{Text: content[offsets[i]:offsets[i+1]]}
+		for j, source := range sources {
+			if start, end := span(source); start <= offsets[i] && offsets[i+1] <= end {
+				segment.SourceIndices = append(segment.SourceIndices, j)
+			}
+		}
+		segments = append(segments, segment)
+	}
+	return segments
+}
+
+// mergeStreamedCitations returns the citations of dst followed by those of
+// src, the candidate of a streamed response chunk whose text starts at byte
+// offset in the text accumulated so far. The citation offsets of a chunk with
+// text are relative to its own text, so they are shifted to index the
+// accumulated text, which is the content of the response; an unset start or
+// end stands for the start or end of the chunk's text. Citations of a chunk
+// without text are taken to index the accumulated text already. Neither dst
+// nor src is modified.
+func mergeStreamedCitations(dst *genai.CitationMetadata, src *genai.Candidate, offset int) *genai.CitationMetadata {
+	merged := &genai.CitationMetadata{}
+	if dst != nil {
+		merged.CitationSources = append(merged.CitationSources, dst.CitationSources...)
+	}
+
+	var length int
+	if src.Content != nil {
+		length = textLength(src.Content.Parts)
+	}
+	for _, source := range src.CitationMetadata.CitationSources {
+		if length > 0 {
+			shifted := *source
+			shifted.StartIndex = genai.Ptr(int32(offset))
+			if source.StartIndex != nil {
+				shifted.StartIndex = genai.Ptr(*source.StartIndex + int32(offset))
+			}
+			shifted.EndIndex = genai.Ptr(int32(offset + length))
+			if source.EndIndex != nil {
+				shifted.EndIndex = genai.Ptr(*source.EndIndex + int32(offset))
+			}
+			source = &shifted
+		}
+		merged.CitationSources = append(merged.CitationSources, source)
+	}
+	return merged
+}
+
+// textLength returns the length in bytes of the text parts of parts.
+func textLength(parts []genai.Part) int {
+	n := 0
+	for _, part := range parts {
+		if text, ok := part.(genai.Text); ok {
+			n += len(text)
+		}
+	}
+	return n
+}
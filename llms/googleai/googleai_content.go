@@ -0,0 +1,53 @@
+package googleai
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/tmc/langchaingo/llms"
+	"github.com/tmc/langchaingo/schema"
+)
+
+// MessageContentFromFiles returns a single human message with text followed
+// by the contents of the files at paths, e.g. images, for quick multimodal
+// experiments. The MIME type of each file is inferred from its extension, or
+// else detected from its data; files of unknown type fail with
+// ErrInvalidMimeType. Errors name the offending path.
+func MessageContentFromFiles(text string, paths ...string) ([]llms.MessageContent, error) {
+	parts := make([]llms.ContentPart, 0, len(paths)+1)
+	parts = append(parts, llms.TextContent{Text: text})
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading %v: %w", path, err)
+		}
+		mimeType, err := fileMIMEType(path, data)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, llms.BinaryContent{MIMEType: mimeType, Data: data})
+	}
+	return []llms.MessageContent{{Role: schema.ChatMessageTypeHuman, Parts: parts}}, nil
+}
+
+// fileMIMEType returns the MIME type of the file at path with data, without
+// parameters.
+func fileMIMEType(path string, data []byte) (string, error) {
+	mimeType := mime.TypeByExtension(filepath.Ext(path))
+	if mimeType == "" {
+		// DetectContentType falls back to application/octet-stream when it
+		// can't recognize the data, which Gemini doesn't accept.
+		mimeType = http.DetectContentType(data)
+		if mimeType == "application/octet-stream" {
+			return "", fmt.Errorf("%w: can't detect mime type of %v", ErrInvalidMimeType, path)
+		}
+	}
+	mediaType, _, err := mime.ParseMediaType(mimeType)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v of %v", ErrInvalidMimeType, mimeType, path)
+	}
+	return mediaType, nil
+}
@@ -0,0 +1,49 @@
+package googleai
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tmc/langchaingo/llms"
+	"github.com/tmc/langchaingo/schema"
+)
+
+func TestMessageContentFromFiles(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	jpeg := []byte("\xff\xd8\xff\xe0\x00\x10JFIF\x00")
+	write := func(name string, data []byte) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, data, 0o600))
+		return path
+	}
+	pngPath := write("gopher.png", png)
+	// Without a known extension, the type is detected from the data.
+	jpegPath := write("gopher", jpeg)
+	unknownPath := write("data", []byte{0, 1, 2, 3})
+
+	got, err := MessageContentFromFiles("Describe these images", pngPath, jpegPath)
+	require.NoError(t, err)
+	assert.Equal(t, []llms.MessageContent{{
+		Role: schema.ChatMessageTypeHuman,
+		Parts: []llms.ContentPart{
+			llms.TextContent{Text: "Describe these images"},
+			llms.BinaryContent{MIMEType: "image/png", Data: png},
+			llms.BinaryContent{MIMEType: "image/jpeg", Data: jpeg},
+		},
+	}}, got)
+
+	missingPath := filepath.Join(dir, "missing.png")
+	_, err = MessageContentFromFiles("Describe", pngPath, missingPath)
+	require.ErrorIs(t, err, os.ErrNotExist)
+	assert.Contains(t, err.Error(), missingPath)
+
+	_, err = MessageContentFromFiles("Describe", unknownPath)
+	require.ErrorIs(t, err, ErrInvalidMimeType)
+	assert.Contains(t, err.Error(), unknownPath)
+}
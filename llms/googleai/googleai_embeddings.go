@@ -0,0 +1,217 @@
+package googleai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"unicode"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/tmc/langchaingo/llms"
+)
+
+// charsPerToken is the approximate number of characters per token used to
+// estimate the token count of texts.
+const charsPerToken = 4
+
+// EmbeddingResult is the outcome of embedding a single text with
+// CreateEmbeddingEach.
+type EmbeddingResult struct {
+	// Embedding is the embedding of the text, or nil if it failed.
+	Embedding []float32
+	// Truncated reports whether the text was truncated to fit before it was
+	// embedded, with WithEmbeddingAutoTruncate.
+	Truncated bool
+	// Err is the reason embedding the text failed, or nil if it succeeded.
+	Err error
+}
+
+// CreateEmbeddingEach creates embeddings from texts like
+// CreateEmbeddingWithOptions, but doesn't stop at the first text that fails,
+// e.g. because it is too long. Instead it returns a result for every text, in
+// order, holding either its embedding or the reason it failed, so that a few
+// bad inputs don't fail a bulk embedding.
+//
+// The returned error is non-nil only if the call as a whole fails. If ctx is
+// done, the texts not embedded yet fail with ctx.Err(), which is also
+// returned.
+func (g *GoogleAI) CreateEmbeddingEach(ctx context.Context, texts []string, options ...EmbeddingOption) ([]EmbeddingResult, error) {
+	if err := g.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer g.release()
+
+	opts, err := g.embeddingOptions(options...)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, span := g.startSpan(ctx, "googleai.CreateEmbedding", opts.model)
+	span.SetAttributes(attrInputCount.Int(len(texts)))
+	em := g.embeddingModelFor(opts)
+	results := make([]EmbeddingResult, len(texts))
+	for i, text := range texts {
+		if err = ctx.Err(); err != nil {
+			for j := i; j < len(texts); j++ {
+				results[j].Err = err
+			}
+			break
+		}
+		results[i].Embedding, results[i].Truncated, results[i].Err = g.embedOne(ctx, em, text)
+		if results[i].Err != nil {
+			results[i].Err = embeddingError(ctx, results[i].Err)
+		}
+	}
+	endSpan(span, err)
+	return results, err
+}
+
+// EmbedContentParts creates a single embedding of parts, which may mix text
+// with images, e.g. for cross-modal retrieval. Parts are converted as for
+// GenerateContent, so images may be given as llms.BinaryContent,
+// llms.ReaderContent or llms.ImageURLContent. Embedding images fails with
+// ErrMultimodalEmbeddingNotSupported if the embedding model is known to only
+// embed text.
+func (g *GoogleAI) EmbedContentParts(ctx context.Context, parts []llms.ContentPart, options ...EmbeddingOption) ([]float32, error) {
+	if err := g.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer g.release()
+
+	opts, err := g.embeddingOptions(options...)
+	if err != nil {
+		return nil, err
+	}
+	for _, part := range parts {
+		if _, ok := part.(llms.TextContent); !ok {
+			if err := checkMultimodalEmbeddingModel(opts.model); err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+	converted, err := g.convertParts(ctx, parts)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, span := g.startSpan(ctx, "googleai.EmbedContentParts", opts.model)
+	em := g.embeddingModelFor(opts)
+	var res *genai.EmbedContentResponse
+	err = g.call(ctx, func() error {
+		var err error
+		res, err = em.EmbedContent(ctx, converted...)
+		return err
+	})
+	endSpan(span, err)
+	if err != nil {
+		return nil, err
+	}
+	return res.Embedding.Values, nil
+}
+
+// embedOne embeds text with em, splitting it into chunks first if
+// WithAutoChunkEmbeddings is used, or truncating it if WithEmbeddingAutoTruncate
+// is. It reports whether text was truncated.
+func (g *GoogleAI) embedOne(ctx context.Context, em embeddingModel, text string) ([]float32, bool, error) {
+	if g.opts.autoChunkEmbeddingTokens > 0 {
+		values, err := g.embedChunked(ctx, em, text)
+		return values, false, err
+	}
+	truncated := false
+	if g.opts.autoTruncateEmbeddingTokens > 0 {
+		if chunks := splitText(text, g.opts.autoTruncateEmbeddingTokens*charsPerToken); len(chunks) > 1 {
+			text, truncated = chunks[0], true
+		}
+	}
+	values, err := g.embedText(ctx, em, text)
+	return values, truncated, err
+}
+
+// embedChunked embeds text with em, first splitting it into chunks if it is
+// estimated to exceed the WithAutoChunkEmbeddings token limit. The vectors of
+// the chunks are averaged, weighted by chunk length.
+func (g *GoogleAI) embedChunked(ctx context.Context, em embeddingModel, text string) ([]float32, error) {
+	chunks := splitText(text, g.opts.autoChunkEmbeddingTokens*charsPerToken)
+	if len(chunks) == 1 {
+		return g.embedText(ctx, em, text)
+	}
+
+	var pooled []float32
+	var totalWeight float32
+	for _, chunk := range chunks {
+		values, err := g.embedText(ctx, em, chunk)
+		if err != nil {
+			return nil, err
+		}
+		if pooled == nil {
+			pooled = make([]float32, len(values))
+		}
+		weight := float32(len([]rune(chunk)))
+		for i, v := range values {
+			pooled[i] += v * weight
+		}
+		totalWeight += weight
+	}
+	for i := range pooled {
+		pooled[i] /= totalWeight
+	}
+	return pooled, nil
+}
+
+// splitText splits text into chunks of at most maxChars characters, breaking
+// at the last whitespace within the limit where possible. Whitespace at chunk
+// boundaries is dropped.
+func splitText(text string, maxChars int) []string {
+	runes := []rune(text)
+	var chunks []string
+	for len(runes) > maxChars {
+		end := maxChars
+		for i := maxChars; i > 0; i-- {
+			if unicode.IsSpace(runes[i]) {
+				end = i
+				break
+			}
+		}
+		if chunk := strings.TrimSpace(string(runes[:end])); chunk != "" {
+			chunks = append(chunks, chunk)
+		}
+		runes = []rune(strings.TrimLeftFunc(string(runes[end:]), unicode.IsSpace))
+	}
+	if chunk := strings.TrimSpace(string(runes)); chunk != "" || len(chunks) == 0 {
+		chunks = append(chunks, chunk)
+	}
+	return chunks
+}
+
+var (
+	// ErrDimensionMismatch is returned by CosineSimilarity for embeddings of
+	// different lengths, e.g. created with different models.
+	ErrDimensionMismatch = errors.New("embeddings have different dimensions")
+	// ErrZeroVector is returned by CosineSimilarity for an empty or all-zero
+	// embedding, whose similarity to anything is undefined.
+	ErrZeroVector = errors.New("embedding is a zero vector")
+)
+
+// CosineSimilarity returns the cosine similarity of the embeddings a and b,
+// from -1 for opposite to 1 for identical directions, e.g. to compare
+// embeddings created with CreateEmbedding. It doesn't allocate, unless it
+// fails with ErrDimensionMismatch or ErrZeroVector.
+func CosineSimilarity(a, b []float32) (float32, error) {
+	if len(a) != len(b) {
+		return 0, fmt.Errorf("%w: %d and %d", ErrDimensionMismatch, len(a), len(b))
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		x, y := float64(a[i]), float64(b[i])
+		dot += x * y
+		normA += x * x
+		normB += y * y
+	}
+	if normA == 0 || normB == 0 {
+		return 0, ErrZeroVector
+	}
+	return float32(dot / (math.Sqrt(normA) * math.Sqrt(normB))), nil
+}
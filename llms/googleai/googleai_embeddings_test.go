@@ -0,0 +1,373 @@
+package googleai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tmc/langchaingo/llms"
+)
+
+// fakeEmbeddingModel embeds texts of the form "text-<n>" as the vector {n},
+// so that each vector identifies its input.
+type fakeEmbeddingModel struct {
+	name     string
+	taskType genai.TaskType
+	titles   []string
+}
+
+func (m *fakeEmbeddingModel) EmbedContent(ctx context.Context, parts ...genai.Part) (*genai.EmbedContentResponse, error) {
+	return m.EmbedContentWithTitle(ctx, "", parts...)
+}
+
+func (m *fakeEmbeddingModel) EmbedContentWithTitle(_ context.Context, title string, parts ...genai.Part) (*genai.EmbedContentResponse, error) {
+	m.titles = append(m.titles, title)
+	text, ok := parts[0].(genai.Text)
+	if !ok {
+		return nil, fmt.Errorf("unexpected part %T", parts[0])
+	}
+	n, err := strconv.Atoi(strings.TrimPrefix(string(text), "text-"))
+	if err != nil {
+		return nil, err
+	}
+	return &genai.EmbedContentResponse{
+		Embedding: &genai.ContentEmbedding{Values: []float32{float32(n)}},
+	}, nil
+}
+
+// newFakeEmbeddingClient returns a GoogleAI whose embedding models are fakes,
+// and the list the fakes are recorded in.
+func newFakeEmbeddingClient(opts ...Option) (*GoogleAI, *[]*fakeEmbeddingModel) {
+	g := &GoogleAI{opts: defaultOptions()}
+	for _, opt := range opts {
+		opt(&g.opts)
+	}
+	var models []*fakeEmbeddingModel
+	g.newEmbeddingModel = func(name string, taskType genai.TaskType) embeddingModel {
+		m := &fakeEmbeddingModel{name: name, taskType: taskType}
+		models = append(models, m)
+		return m
+	}
+	return g, &models
+}
+
+func TestCreateEmbeddingOrder(t *testing.T) {
+	t.Parallel()
+	g, _ := newFakeEmbeddingClient()
+
+	const n = 250
+	texts := make([]string, n)
+	for i := range texts {
+		texts[i] = fmt.Sprintf("text-%d", i)
+	}
+
+	res, err := g.CreateEmbedding(context.Background(), texts)
+	require.NoError(t, err)
+	require.Len(t, res, n)
+	for i, v := range res {
+		assert.Equal(t, []float32{float32(i)}, v, "embedding %d", i)
+	}
+}
+
+// lengthEmbeddingModel embeds a text as the vector {len(text), 1}.
+type lengthEmbeddingModel struct {
+	texts []string
+}
+
+func (m *lengthEmbeddingModel) EmbedContent(ctx context.Context, parts ...genai.Part) (*genai.EmbedContentResponse, error) {
+	return m.EmbedContentWithTitle(ctx, "", parts...)
+}
+
+func (m *lengthEmbeddingModel) EmbedContentWithTitle(_ context.Context, _ string, parts ...genai.Part) (*genai.EmbedContentResponse, error) {
+	text, _ := parts[0].(genai.Text)
+	m.texts = append(m.texts, string(text))
+	return &genai.EmbedContentResponse{
+		Embedding: &genai.ContentEmbedding{Values: []float32{float32(len(text)), 1}},
+	}, nil
+}
+
+func TestCreateEmbeddingAutoChunk(t *testing.T) {
+	t.Parallel()
+
+	em := &lengthEmbeddingModel{}
+	g := &GoogleAI{opts: defaultOptions()}
+	WithAutoChunkEmbeddings(2)(&g.opts)
+	g.newEmbeddingModel = func(string, genai.TaskType) embeddingModel { return em }
+
+	// "aaaaaaa bbb" is 11 characters, over the 8 character limit, and is split
+	// into chunks of 7 and 3 characters.
+	res, err := g.CreateEmbedding(context.Background(), []string{"short", "aaaaaaa bbb"})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"short", "aaaaaaa", "bbb"}, em.texts)
+	assert.Equal(t, []float32{5, 1}, res[0])
+	assert.InDelta(t, (7*7+3*3)/10.0, res[1][0], 1e-6)
+	assert.InDelta(t, 1, res[1][1], 1e-6)
+}
+
+func TestSplitText(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, []string{""}, splitText("", 4))
+	assert.Equal(t, []string{"abc"}, splitText("abc", 4))
+	assert.Equal(t, []string{"ab cd", "ef"}, splitText("ab cd ef", 5))
+	assert.Equal(t, []string{"abcd", "efgh", "ij"}, splitText("abcdefghij", 4))
+	assert.Equal(t, []string{"ééé", "ééé"}, splitText("ééé ééé", 4))
+}
+
+// cancelingEmbeddingModel cancels the context after embedding n texts, and
+// then fails like an HTTP request with a canceled context.
+type cancelingEmbeddingModel struct {
+	fakeEmbeddingModel
+	n      int
+	cancel context.CancelFunc
+}
+
+func (m *cancelingEmbeddingModel) EmbedContent(ctx context.Context, parts ...genai.Part) (*genai.EmbedContentResponse, error) {
+	if m.n == 0 {
+		m.cancel()
+		return nil, fmt.Errorf("Post \"https://generativelanguage.googleapis.com\": %w", ctx.Err())
+	}
+	m.n--
+	return m.fakeEmbeddingModel.EmbedContent(ctx, parts...)
+}
+
+func TestCreateEmbeddingCanceledResume(t *testing.T) {
+	t.Parallel()
+
+	texts := make([]string, 10)
+	for i := range texts {
+		texts[i] = fmt.Sprintf("text-%d", i)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	g := &GoogleAI{opts: defaultOptions()}
+	g.newEmbeddingModel = func(string, genai.TaskType) embeddingModel {
+		return &cancelingEmbeddingModel{n: 4, cancel: cancel}
+	}
+
+	results, err := g.CreateEmbedding(ctx, texts)
+	require.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, [][]float32{{0}, {1}, {2}, {3}}, results)
+
+	// Resume with the remaining texts.
+	g, _ = newFakeEmbeddingClient()
+	rest, err := g.CreateEmbedding(context.Background(), texts[len(results):])
+	require.NoError(t, err)
+	results = append(results, rest...)
+	require.Len(t, results, len(texts))
+	for i, values := range results {
+		assert.Equal(t, []float32{float32(i)}, values)
+	}
+}
+
+func TestCreateEmbeddingEach(t *testing.T) {
+	t.Parallel()
+	g, _ := newFakeEmbeddingClient()
+
+	results, err := g.CreateEmbeddingEach(context.Background(), []string{"text-0", "bad", "text-2"})
+	require.NoError(t, err)
+	require.Len(t, results, 3)
+	assert.Equal(t, EmbeddingResult{Embedding: []float32{0}}, results[0])
+	assert.Nil(t, results[1].Embedding)
+	require.Error(t, results[1].Err)
+	assert.Equal(t, EmbeddingResult{Embedding: []float32{2}}, results[2])
+}
+
+func TestCreateEmbeddingEachCanceled(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	g := &GoogleAI{opts: defaultOptions()}
+	g.newEmbeddingModel = func(string, genai.TaskType) embeddingModel {
+		return &cancelingEmbeddingModel{n: 1, cancel: cancel}
+	}
+
+	results, err := g.CreateEmbeddingEach(ctx, []string{"text-0", "text-1", "text-2"})
+	require.ErrorIs(t, err, context.Canceled)
+	require.Len(t, results, 3)
+	assert.Equal(t, EmbeddingResult{Embedding: []float32{0}}, results[0])
+	assert.Equal(t, EmbeddingResult{Err: context.Canceled}, results[1])
+	assert.Equal(t, EmbeddingResult{Err: context.Canceled}, results[2])
+}
+
+func TestEmbeddingAutoTruncate(t *testing.T) {
+	t.Parallel()
+
+	em := &lengthEmbeddingModel{}
+	g := &GoogleAI{opts: defaultOptions()}
+	WithEmbeddingAutoTruncate(2)(&g.opts)
+	g.newEmbeddingModel = func(string, genai.TaskType) embeddingModel { return em }
+
+	// "aaaaaaa bbb" is 11 characters, over the 8 character limit, and is
+	// truncated to "aaaaaaa".
+	results, err := g.CreateEmbeddingEach(context.Background(), []string{"short", "aaaaaaa bbb"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"short", "aaaaaaa"}, em.texts)
+	assert.Equal(t, []EmbeddingResult{
+		{Embedding: []float32{5, 1}},
+		{Embedding: []float32{7, 1}, Truncated: true},
+	}, results)
+
+	res, err := g.CreateEmbedding(context.Background(), []string{"aaaaaaa bbb"})
+	require.NoError(t, err)
+	assert.Equal(t, [][]float32{{7, 1}}, res)
+}
+
+func TestCreateEmbeddingWithOptions(t *testing.T) {
+	t.Parallel()
+	g, models := newFakeEmbeddingClient()
+	ctx := context.Background()
+
+	_, err := g.CreateEmbeddingWithOptions(ctx, []string{"text-0"},
+		WithEmbeddingModel("text-embedding-004"), WithEmbeddingTaskType(genai.TaskTypeRetrievalQuery))
+	require.NoError(t, err)
+	_, err = g.CreateEmbeddingWithOptions(ctx, []string{"text-1"}, WithEmbeddingTitle("Gophers"))
+	require.NoError(t, err)
+
+	require.Len(t, *models, 2)
+	assert.Equal(t, "text-embedding-004", (*models)[0].name)
+	assert.Equal(t, genai.TaskTypeRetrievalQuery, (*models)[0].taskType)
+	assert.Equal(t, []string{""}, (*models)[0].titles)
+	assert.Equal(t, genai.TaskTypeRetrievalDocument, (*models)[1].taskType)
+	assert.Equal(t, []string{"Gophers"}, (*models)[1].titles)
+
+	_, err = g.CreateEmbeddingWithOptions(ctx, []string{"text-2"},
+		WithEmbeddingTitle("Gophers"), WithEmbeddingTaskType(genai.TaskTypeRetrievalQuery))
+	require.ErrorIs(t, err, ErrInvalidOptions)
+}
+
+// partsEmbeddingModel records the parts it embeds and embeds them as the
+// vector {len(parts)}.
+type partsEmbeddingModel struct {
+	parts []genai.Part
+}
+
+func (m *partsEmbeddingModel) EmbedContent(ctx context.Context, parts ...genai.Part) (*genai.EmbedContentResponse, error) {
+	return m.EmbedContentWithTitle(ctx, "", parts...)
+}
+
+func (m *partsEmbeddingModel) EmbedContentWithTitle(_ context.Context, _ string, parts ...genai.Part) (*genai.EmbedContentResponse, error) {
+	m.parts = parts
+	return &genai.EmbedContentResponse{
+		Embedding: &genai.ContentEmbedding{Values: []float32{float32(len(parts))}},
+	}, nil
+}
+
+func TestEmbedContentParts(t *testing.T) {
+	t.Parallel()
+
+	em := &partsEmbeddingModel{}
+	g := &GoogleAI{opts: defaultOptions()}
+	g.newEmbeddingModel = func(string, genai.TaskType) embeddingModel { return em }
+
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	parts := []llms.ContentPart{
+		llms.TextContent{Text: "A gopher"},
+		llms.BinaryContent{MIMEType: "image/png", Data: png},
+	}
+
+	// Unknown models are let through.
+	values, err := g.EmbedContentParts(context.Background(), parts, WithEmbeddingModel("multimodal-embedding"))
+	require.NoError(t, err)
+	assert.Equal(t, []float32{2}, values)
+	assert.Equal(t, []genai.Part{genai.Text("A gopher"), genai.Blob{MIMEType: "image/png", Data: png}}, em.parts)
+
+	_, err = g.EmbedContentParts(context.Background(), parts)
+	require.ErrorIs(t, err, ErrMultimodalEmbeddingNotSupported)
+
+	values, err = g.EmbedContentParts(context.Background(), parts[:1])
+	require.NoError(t, err)
+	assert.Equal(t, []float32{1}, values)
+}
+
+func TestCosineSimilarity(t *testing.T) {
+	t.Parallel()
+
+	similarity := func(a, b []float32) float32 {
+		t.Helper()
+		s, err := CosineSimilarity(a, b)
+		require.NoError(t, err)
+		return s
+	}
+	assert.InDelta(t, 1, similarity([]float32{1, 2, 3}, []float32{2, 4, 6}), 1e-6)
+	assert.InDelta(t, 0, similarity([]float32{1, 0}, []float32{0, 1}), 1e-6)
+	assert.InDelta(t, -1, similarity([]float32{1, 1}, []float32{-1, -1}), 1e-6)
+	assert.InDelta(t, 0.5, similarity([]float32{1, 0}, []float32{1, float32(math.Sqrt(3))}), 1e-6)
+
+	_, err := CosineSimilarity([]float32{1, 2}, []float32{1, 2, 3})
+	require.ErrorIs(t, err, ErrDimensionMismatch)
+	_, err = CosineSimilarity([]float32{0, 0}, []float32{1, 2})
+	require.ErrorIs(t, err, ErrZeroVector)
+	_, err = CosineSimilarity(nil, nil)
+	require.ErrorIs(t, err, ErrZeroVector)
+}
+
+func BenchmarkCosineSimilarity(b *testing.B) {
+	x, y := make([]float32, 768), make([]float32, 768)
+	for i := range x {
+		x[i], y[i] = float32(i), float32(len(y)-i)
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, _ = CosineSimilarity(x, y)
+	}
+}
+
+func TestCreateEmbeddingWithOptionsTaskTypePerCall(t *testing.T) {
+	t.Parallel()
+
+	var mu sync.Mutex
+	var taskTypes []genai.TaskType
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			// The task type is sent as the enum number.
+			TaskType genai.TaskType `json:"taskType"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		mu.Lock()
+		taskTypes = append(taskTypes, req.TaskType)
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"embedding": {"values": [1, 2]}}`))
+	}))
+	defer srv.Close()
+
+	// A RAG pipeline embedding documents and then a query with the same
+	// client.
+	g := newFakeAPIClient(t, srv.URL)
+	ctx := context.Background()
+	_, err := g.CreateEmbeddingWithOptions(ctx, []string{"doc 1", "doc 2"}, WithEmbeddingTaskType(genai.TaskTypeRetrievalDocument))
+	require.NoError(t, err)
+	res, err := g.CreateEmbeddingWithOptions(ctx, []string{"query"}, WithEmbeddingTaskType(genai.TaskTypeRetrievalQuery))
+	require.NoError(t, err)
+	assert.Equal(t, [][]float32{{1, 2}}, res)
+	_, err = g.CreateEmbeddingWithOptions(ctx, []string{"doc 3"}, WithEmbeddingTaskType(genai.TaskTypeRetrievalDocument))
+	require.NoError(t, err)
+	// Without a task type, none is sent.
+	_, err = g.CreateEmbedding(ctx, []string{"text"})
+	require.NoError(t, err)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []genai.TaskType{
+		genai.TaskTypeRetrievalDocument,
+		genai.TaskTypeRetrievalDocument,
+		genai.TaskTypeRetrievalQuery,
+		genai.TaskTypeRetrievalDocument,
+		genai.TaskTypeUnspecified,
+	}, taskTypes)
+}
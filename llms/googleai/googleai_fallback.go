@@ -0,0 +1,92 @@
+package googleai
+
+import (
+	"context"
+	"errors"
+	"log"
+	"maps"
+	"net/http"
+
+	"github.com/tmc/langchaingo/llms"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// MODEL_USED is the GenerationInfo key under which the model that served a
+// request is reported when a fallback chain is set with
+// WithModelFallbackChain.
+const MODEL_USED = "model_used" //nolint:revive,stylecheck
+
+// generateWithFallback generates content from messages with the model of
+// opts and, if that fails with an error worth falling back on, with the
+// models of the fallback chain in turn. The messages are converted once and
+// the same contents are sent to every model. Streaming calls only fall back as long
+// as nothing has been streamed yet.
+func (g *GoogleAI) generateWithFallback(ctx context.Context, messages []llms.MessageContent, opts *llms.CallOptions) (*llms.ContentResponse, error) {
+	contents, err := g.convertMessages(ctx, messages)
+	if err != nil {
+		return nil, err
+	}
+	chain := g.opts.modelFallbackChain
+	if len(chain) == 0 {
+		return g.generate(ctx, g.generativeModel(opts.Model, opts), contents, opts)
+	}
+
+	streamed := false
+	opts = withStreamedFlag(opts, &streamed)
+	models := append([]string{opts.Model}, chain...)
+	for i := 0; ; i++ {
+		name := models[i]
+		modelOpts := *opts
+		modelOpts.Model = name
+		resp, err := g.generate(ctx, g.generativeModel(name, &modelOpts), contents, &modelOpts)
+		if err != nil && i+1 < len(models) && !streamed && g.shouldFallback(err) {
+			log.Printf("[WARN] googleai: model %v failed, falling back to %v: %v", name, models[i+1], err)
+			continue
+		}
+		if resp != nil {
+			for _, choice := range resp.Choices {
+				if choice.GenerationInfo == nil {
+					choice.GenerationInfo = make(map[string]any)
+				}
+				choice.GenerationInfo[MODEL_USED] = name
+			}
+		}
+		return resp, err
+	}
+}
+
+// withStreamedFlag returns a copy of opts whose streaming functions set
+// *streamed once they are called.
+func withStreamedFlag(opts *llms.CallOptions, streamed *bool) *llms.CallOptions {
+	flagged := *opts
+	if streamingFunc := opts.StreamingFunc; streamingFunc != nil {
+		flagged.StreamingFunc = func(ctx context.Context, chunk []byte) error {
+			*streamed = true
+			return streamingFunc(ctx, chunk)
+		}
+	}
+	if streamingFunc := streamingFuncN(opts); streamingFunc != nil {
+		flagged.Metadata = maps.Clone(opts.Metadata)
+		flagged.Metadata[streamingFuncNMetadataKey] = func(ctx context.Context, index int, chunk []byte) error {
+			*streamed = true
+			return streamingFunc(ctx, index, chunk)
+		}
+	}
+	return &flagged
+}
+
+// shouldFallback reports whether a request failing with err should be retried
+// with the next model of the fallback chain: if err is retryable according to
+// the client's classifier, or the model is overloaded or not found.
+func (g *GoogleAI) shouldFallback(err error) bool {
+	if g.retryable(err) || errors.Is(err, ErrModelOverloaded) {
+		return true
+	}
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Code == http.StatusNotFound
+	}
+	return status.Code(err) == codes.NotFound
+}
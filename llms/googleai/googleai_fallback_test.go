@@ -0,0 +1,131 @@
+package googleai
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tmc/langchaingo/llms"
+	"github.com/tmc/langchaingo/schema"
+	"google.golang.org/api/googleapi"
+)
+
+func TestWithModelFallbackChain(t *testing.T) {
+	t.Parallel()
+
+	var mu sync.Mutex
+	var requested []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		model := strings.TrimSuffix(r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:], ":generateContent")
+		mu.Lock()
+		requested = append(requested, model)
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		switch model {
+		case "exhausted":
+			// Unavailable errors are retried by genai itself, for up to a
+			// minute.
+			w.WriteHeader(http.StatusTooManyRequests)
+			_, _ = w.Write([]byte(`{"error": {"code": 429, "message": "Resource has been exhausted.", "status": "RESOURCE_EXHAUSTED"}}`))
+		case "missing":
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"error": {"code": 404, "message": "models/missing is not found.", "status": "NOT_FOUND"}}`))
+		case "invalid":
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error": {"code": 400, "message": "Invalid request.", "status": "INVALID_ARGUMENT"}}`))
+		default:
+			_, _ = w.Write([]byte(fakeAPIResponse))
+		}
+	}))
+	defer srv.Close()
+
+	messages := []llms.MessageContent{{
+		Role:  schema.ChatMessageTypeHuman,
+		Parts: []llms.ContentPart{llms.TextContent{Text: "Hello"}},
+	}}
+	generate := func(chain []string, model string) (*llms.ContentResponse, []string, error) {
+		mu.Lock()
+		requested = nil
+		mu.Unlock()
+		g := newFakeAPIClient(t, srv.URL, WithModelFallbackChain(chain))
+		resp, err := g.GenerateContent(context.Background(), messages, llms.WithModel(model))
+		mu.Lock()
+		defer mu.Unlock()
+		return resp, requested, err
+	}
+
+	resp, models, err := generate([]string{"missing", "backup", "unused"}, "exhausted")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"exhausted", "missing", "backup"}, models)
+	assert.Equal(t, "Hi", resp.Choices[0].Content)
+	assert.Equal(t, "backup", resp.Choices[0].GenerationInfo[MODEL_USED])
+
+	resp, models, err = generate([]string{"backup"}, "primary")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"primary"}, models)
+	assert.Equal(t, "primary", resp.Choices[0].GenerationInfo[MODEL_USED])
+
+	// Errors other than retryable and model unavailable errors don't fall
+	// back, and the last model's error is returned.
+	_, models, err = generate([]string{"backup"}, "invalid")
+	var apiErr *googleapi.Error
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusBadRequest, apiErr.Code)
+	assert.Equal(t, []string{"invalid"}, models)
+
+	_, models, err = generate([]string{"missing"}, "exhausted")
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusNotFound, apiErr.Code)
+	assert.Equal(t, []string{"exhausted", "missing"}, models)
+}
+
+func TestWithModelFallbackChainReaderContent(t *testing.T) {
+	t.Parallel()
+
+	var mu sync.Mutex
+	blobs := make(map[string]string)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		model := strings.TrimSuffix(r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:], ":generateContent")
+		var req struct {
+			Contents []struct {
+				Parts []struct {
+					InlineData struct {
+						Data []byte `json:"data"`
+					} `json:"inlineData"`
+				} `json:"parts"`
+			} `json:"contents"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		mu.Lock()
+		blobs[model] = string(req.Contents[0].Parts[0].InlineData.Data)
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		if model == "primary" {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"error": {"code": 404, "message": "models/primary is not found.", "status": "NOT_FOUND"}}`))
+			return
+		}
+		_, _ = w.Write([]byte(fakeAPIResponse))
+	}))
+	defer srv.Close()
+
+	g := newFakeAPIClient(t, srv.URL, WithModelFallbackChain([]string{"backup"}))
+	resp, err := g.GenerateContent(context.Background(), []llms.MessageContent{{
+		Role: schema.ChatMessageTypeHuman,
+		Parts: []llms.ContentPart{
+			llms.ReaderContent{MIMEType: "image/png", Reader: strings.NewReader("png data")},
+			llms.TextContent{Text: "Describe this image"},
+		},
+	}}, llms.WithModel("primary"))
+	require.NoError(t, err)
+	assert.Equal(t, "backup", resp.Choices[0].GenerationInfo[MODEL_USED])
+	assert.Equal(t, map[string]string{"primary": "png data", "backup": "png data"}, blobs)
+}
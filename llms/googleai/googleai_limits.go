@@ -0,0 +1,173 @@
+package googleai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/tmc/langchaingo/llms"
+	"google.golang.org/api/iterator"
+)
+
+// ErrModelNotFound is returned by ModelTokenLimits for models the API doesn't
+// list.
+var ErrModelNotFound = errors.New("model not found")
+
+// tokenLimitsRetryInterval is how long a failure to fetch the model list
+// for the token limits is returned from the cache before fetching it again.
+const tokenLimitsRetryInterval = time.Minute
+
+// inputLimitWarningRatio is the fraction of a model's input token limit an
+// estimated prompt size has to reach for WithAutoTokenLimits to warn about it.
+const inputLimitWarningRatio = 0.9
+
+// TokenLimits are the token limits of a model.
+type TokenLimits struct {
+	// Input is the maximum number of input tokens.
+	Input int
+	// Output is the maximum number of output tokens.
+	Output int
+}
+
+// ModelTokenLimits returns the token limits of model, which may be given with
+// or without the "models/" prefix, as reported by the API's model list. The
+// list is fetched on first use and cached for the lifetime of the client. A
+// failure to fetch it is cached for tokenLimitsRetryInterval.
+func (g *GoogleAI) ModelTokenLimits(ctx context.Context, model string) (TokenLimits, error) {
+	all, _, err := g.listTokenLimits(ctx)
+	if err != nil {
+		return TokenLimits{}, err
+	}
+	limits, ok := all[strings.TrimPrefix(model, "models/")]
+	if !ok {
+		return TokenLimits{}, fmt.Errorf("%w: %v", ErrModelNotFound, model)
+	}
+	return limits, nil
+}
+
+// listTokenLimits returns the token limits of the listed models by name,
+// from the cache if possible. listed reports whether the model list was
+// fetched by this call. The lock is only held to access the cache, so
+// concurrent callers don't wait for each other's request.
+func (g *GoogleAI) listTokenLimits(ctx context.Context) (limits map[string]TokenLimits, listed bool, err error) {
+	g.tokenLimitsMu.Lock()
+	limits, err = g.tokenLimits, g.tokenLimitsErr
+	if err != nil && g.timeNow().Sub(g.tokenLimitsErrAt) >= tokenLimitsRetryInterval {
+		err = nil
+	}
+	g.tokenLimitsMu.Unlock()
+	if limits != nil || err != nil {
+		return limits, false, err
+	}
+
+	limits, err = g.fetchTokenLimits(ctx)
+
+	g.tokenLimitsMu.Lock()
+	defer g.tokenLimitsMu.Unlock()
+	if err != nil {
+		// A canceled call says nothing about the API.
+		if ctx.Err() == nil {
+			g.tokenLimitsErr, g.tokenLimitsErrAt = err, g.timeNow()
+		}
+		return nil, true, err
+	}
+	g.tokenLimits, g.tokenLimitsErr = limits, nil
+	return limits, true, nil
+}
+
+// fetchTokenLimits fetches the model list and returns the token limits of the
+// listed models by name.
+func (g *GoogleAI) fetchTokenLimits(ctx context.Context) (map[string]TokenLimits, error) {
+	limits := make(map[string]TokenLimits)
+	it := g.genaiClient().ListModels(ctx)
+	for {
+		m, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			return limits, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		limits[strings.TrimPrefix(m.Name, "models/")] = TokenLimits{
+			Input:  int(m.InputTokenLimit),
+			Output: int(m.OutputTokenLimit),
+		}
+	}
+}
+
+// applyTokenLimits sets the max tokens of opts to the model's output token
+// limit if neither opts nor the base generation config set them, and warns
+// when the estimated number of prompt tokens approaches the model's input
+// token limit. If the limits can't be looked up, the client's default max
+// tokens are used.
+func (g *GoogleAI) applyTokenLimits(ctx context.Context, opts *llms.CallOptions, promptTokens int) {
+	all, listed, err := g.listTokenLimits(ctx)
+	limits, ok := all[strings.TrimPrefix(opts.Model, "models/")]
+	switch {
+	case err != nil && listed:
+		// A failure is only logged when it happens, not on every call
+		// it's cached for.
+		log.Printf("[WARN] googleai: can't look up the token limits of %v, using the default max tokens: %v", opts.Model, err)
+	case err == nil && !ok && g.firstTokenLimitsMiss(opts.Model):
+		log.Printf("[WARN] googleai: can't look up the token limits of %v, using the default max tokens: %v",
+			opts.Model, ErrModelNotFound)
+	}
+
+	if opts.MaxTokens == 0 && g.baseGenerationConfig(opts).MaxOutputTokens == nil {
+		opts.MaxTokens = int(g.opts.defaultMaxTokens)
+		if limits.Output > 0 {
+			opts.MaxTokens = limits.Output
+		}
+	}
+
+	if limits.Input > 0 {
+		if float64(promptTokens) >= inputLimitWarningRatio*float64(limits.Input) {
+			log.Printf("[WARN] googleai: the prompt of about %d tokens approaches the input token limit of %d of %v",
+				promptTokens, limits.Input, opts.Model)
+		}
+	}
+}
+
+// firstTokenLimitsMiss reports whether model is missing from the model list for
+// the first time, so that this is only warned about once per model.
+func (g *GoogleAI) firstTokenLimitsMiss(model string) bool {
+	g.tokenLimitsMu.Lock()
+	defer g.tokenLimitsMu.Unlock()
+	if g.tokenLimitsMissed[model] {
+		return false
+	}
+	if g.tokenLimitsMissed == nil {
+		g.tokenLimitsMissed = make(map[string]bool)
+	}
+	g.tokenLimitsMissed[model] = true
+	return true
+}
+
+// estimateTokens estimates the number of tokens of the text in messages.
+func estimateTokens(messages []llms.MessageContent) int {
+	chars := 0
+	for _, mc := range messages {
+		for _, part := range mc.Parts {
+			if text, ok := part.(llms.TextContent); ok {
+				chars += utf8.RuneCountInString(text.Text)
+			}
+		}
+	}
+	return chars / charsPerToken
+}
+
+// estimateRawTokens estimates the number of tokens of the text in parts.
+func estimateRawTokens(parts []genai.Part) int {
+	chars := 0
+	for _, part := range parts {
+		if text, ok := part.(genai.Text); ok {
+			chars += utf8.RuneCountInString(string(text))
+		}
+	}
+	return chars / charsPerToken
+}
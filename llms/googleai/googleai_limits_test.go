@@ -0,0 +1,157 @@
+package googleai
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tmc/langchaingo/llms"
+	"github.com/tmc/langchaingo/schema"
+)
+
+// fakeModelList lists a single model with its token limits.
+const fakeModelList = `{"models": [{"name": "models/gemini-pro", "inputTokenLimit": 30720, "outputTokenLimit": 2048}]}`
+
+// newFakeLimitsServer serves fakeModelList and generateContent requests. It
+// counts the model list requests and records the generation configs sent.
+func newFakeLimitsServer(t *testing.T) (*httptest.Server, *atomic.Int32, *[]map[string]any) {
+	t.Helper()
+
+	var listed atomic.Int32
+	var configs []map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/models") {
+			listed.Add(1)
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(fakeModelList))
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		var req struct {
+			GenerationConfig map[string]any `json:"generationConfig"`
+		}
+		_ = json.Unmarshal(body, &req)
+		configs = append(configs, req.GenerationConfig)
+		writeFakeAPIResponse(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &listed, &configs
+}
+
+func TestModelTokenLimits(t *testing.T) {
+	t.Parallel()
+
+	srv, listed, _ := newFakeLimitsServer(t)
+	g := newFakeAPIClient(t, srv.URL)
+	ctx := context.Background()
+
+	limits, err := g.ModelTokenLimits(ctx, "gemini-pro")
+	require.NoError(t, err)
+	assert.Equal(t, TokenLimits{Input: 30720, Output: 2048}, limits)
+
+	limits, err = g.ModelTokenLimits(ctx, "models/gemini-pro")
+	require.NoError(t, err)
+	assert.Equal(t, TokenLimits{Input: 30720, Output: 2048}, limits)
+
+	_, err = g.ModelTokenLimits(ctx, "gemini-ultra")
+	require.ErrorIs(t, err, ErrModelNotFound)
+
+	assert.Equal(t, int32(1), listed.Load())
+}
+
+func TestModelTokenLimitsCachesFailure(t *testing.T) {
+	t.Parallel()
+
+	var listed atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if listed.Add(1) == 1 {
+			http.Error(w, `{"error": {"code": 403, "message": "denied"}}`, http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(fakeModelList))
+	}))
+	t.Cleanup(srv.Close)
+
+	g := newFakeAPIClient(t, srv.URL)
+	now := time.Now()
+	g.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	_, err := g.ModelTokenLimits(ctx, "gemini-pro")
+	require.Error(t, err)
+	_, err = g.ModelTokenLimits(ctx, "gemini-pro")
+	require.Error(t, err)
+	assert.Equal(t, int32(1), listed.Load(), "the failure must be cached")
+
+	now = now.Add(tokenLimitsRetryInterval)
+	limits, err := g.ModelTokenLimits(ctx, "gemini-pro")
+	require.NoError(t, err)
+	assert.Equal(t, TokenLimits{Input: 30720, Output: 2048}, limits)
+	assert.Equal(t, int32(2), listed.Load())
+}
+
+func TestAutoTokenLimits(t *testing.T) {
+	t.Parallel()
+
+	srv, _, configs := newFakeLimitsServer(t)
+	g := newFakeAPIClient(t, srv.URL, WithAutoTokenLimits())
+	ctx := context.Background()
+	messages := []llms.MessageContent{{
+		Role:  schema.ChatMessageTypeHuman,
+		Parts: []llms.ContentPart{llms.TextContent{Text: "Hello"}},
+	}}
+
+	_, err := g.GenerateContent(ctx, messages)
+	require.NoError(t, err)
+	_, err = g.GenerateContent(ctx, messages, llms.WithMaxTokens(100))
+	require.NoError(t, err)
+
+	require.Len(t, *configs, 2)
+	assert.EqualValues(t, 2048, (*configs)[0]["maxOutputTokens"])
+	assert.EqualValues(t, 100, (*configs)[1]["maxOutputTokens"])
+}
+
+func TestAutoTokenLimitsGenerateRaw(t *testing.T) {
+	t.Parallel()
+
+	srv, _, configs := newFakeLimitsServer(t)
+	g := newFakeAPIClient(t, srv.URL, WithAutoTokenLimits())
+	ctx := context.Background()
+
+	_, err := g.GenerateRaw(ctx, "gemini-pro", []genai.Part{genai.Text("Hello")})
+	require.NoError(t, err)
+	_, err = g.GenerateRaw(ctx, "gemini-pro", []genai.Part{genai.Text("Hello")}, llms.WithMaxTokens(100))
+	require.NoError(t, err)
+
+	require.Len(t, *configs, 2)
+	assert.EqualValues(t, 2048, (*configs)[0]["maxOutputTokens"])
+	assert.EqualValues(t, 100, (*configs)[1]["maxOutputTokens"])
+}
+
+func TestFirstTokenLimitsMiss(t *testing.T) {
+	t.Parallel()
+	g := &GoogleAI{}
+
+	assert.True(t, g.firstTokenLimitsMiss("gemini-ultra"))
+	assert.False(t, g.firstTokenLimitsMiss("gemini-ultra"))
+	assert.True(t, g.firstTokenLimitsMiss("gemini-nano"))
+}
+
+func TestEstimateTokens(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, 3, estimateTokens([]llms.MessageContent{
+		{Parts: []llms.ContentPart{llms.TextContent{Text: "abcdefgh"}, llms.BinaryContent{Data: []byte("ignored")}}},
+		{Parts: []llms.ContentPart{llms.TextContent{Text: "éééé"}}},
+	}))
+}
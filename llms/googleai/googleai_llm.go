@@ -6,24 +6,58 @@ package googleai
 
 import (
 	"context"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"mime"
 	"net/http"
+	"os"
+	"slices"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/google/generative-ai-go/genai"
 	"github.com/tmc/langchaingo/llms"
 	"github.com/tmc/langchaingo/schema"
 	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
+	htransport "google.golang.org/api/transport/http"
 )
 
 // GoogleAI is a type that represents a Google AI API client.
 type GoogleAI struct {
-	client *genai.Client
-	opts   options
+	// clientMu guards client and the API key in opts, which are replaced by
+	// UpdateAPIKey.
+	clientMu sync.RWMutex
+	client   *genai.Client
+	opts     options
+	breaker  *circuitBreaker
+	// sem limits the number of concurrent requests if non-nil.
+	sem chan struct{}
+	// newEmbeddingModel replaces the client's embedding models if non-nil,
+	// e.g. with a fake in tests.
+	newEmbeddingModel func(name string, taskType genai.TaskType) embeddingModel
+	// now replaces time.Now if non-nil, e.g. with a fake clock in tests.
+	now func() time.Time
+
+	tokenLimitsMu sync.Mutex
+	// tokenLimits caches the token limits of the listed models by name.
+	tokenLimits map[string]TokenLimits
+	// tokenLimitsErr caches the last failure to list the models, which
+	// happened at tokenLimitsErrAt.
+	tokenLimitsErr   error
+	tokenLimitsErrAt time.Time
+	// tokenLimitsMissed holds the models missing from the model list that
+	// have been warned about.
+	tokenLimitsMissed map[string]bool
+}
+
+// embeddingModel is implemented by *genai.EmbeddingModel.
+type embeddingModel interface {
+	EmbedContent(ctx context.Context, parts ...genai.Part) (*genai.EmbedContentResponse, error)
+	EmbedContentWithTitle(ctx context.Context, title string, parts ...genai.Part) (*genai.EmbedContentResponse, error)
 }
 
 var (
@@ -33,27 +67,70 @@ var (
 	ErrUnknownPartInResponse  = errors.New("unknown part type in generation response")
 	ErrInvalidMimeType        = errors.New("invalid mime type on content")
 	ErrSystemRoleNotSupported = errors.New("system role isn't supporeted yet")
+	ErrLastMessageNotFromUser = errors.New("gemini requires the final message to be from the user (human)")
+	ErrNoMessages             = errors.New("no messages to generate content from")
+	ErrInlineDataTooLarge     = errors.New("inline data too large")
+	ErrEmptyMessage           = errors.New("empty message")
+	ErrMultiTurnNotSupported  = errors.New("model doesn't support multi-turn chat")
+	ErrToolsNotSupported      = errors.New("model doesn't support tools")
+	ErrWrongModelKind         = errors.New("wrong kind of model")
+	ErrModelNotAllowed        = errors.New("model not allowed")
+	ErrStreamAborted          = errors.New("streaming aborted by the streaming function")
+	ErrMultipleChoices        = errors.New("more than one choice in generation response")
+	ErrTooManyParts           = errors.New("too many content parts in request")
+	ErrMissingAPIKey          = fmt.Errorf("missing the Google AI API key, pass it with WithAPIKey or set one of the %s environment variables", strings.Join(apiKeyEnvVarNames, ", "))
 )
 
 const (
-	CITATIONS = "citations"
-	SAFETY    = "safety"
-	RoleModel = "model"
-	RoleUser  = "user"
+	CITATIONS    = "citations"
+	SAFETY       = "safety"
+	SAFETY_ALL   = "safety_all" //nolint:revive,stylecheck
+	SEGMENTS     = "segments"
+	INLINE_DATA  = "inline_data"  //nolint:revive,stylecheck
+	BLOCK_REASON = "block_reason" //nolint:revive,stylecheck
+	EMPTY_ANSWER = "empty_answer" //nolint:revive,stylecheck
+	RoleModel    = "model"
+	RoleUser     = "user"
 )
 
-// NewGoogleAI creates a new GoogleAI struct.
+// NewGoogleAI creates a new GoogleAI struct. If neither an API key nor a token
+// source is passed with WithAPIKey or WithTokenSource, the API key is read from
+// the environment; see WithAPIKey for details.
 func NewGoogleAI(ctx context.Context, opts ...Option) (*GoogleAI, error) {
 	clientOptions := defaultOptions()
 	for _, opt := range opts {
 		opt(&clientOptions)
 	}
 
+	if clientOptions.apiKey != "" && clientOptions.tokenSource != nil {
+		return nil, fmt.Errorf("%w: WithAPIKey and WithTokenSource are mutually exclusive", ErrInvalidOptions)
+	}
+	if clientOptions.quotaProject != "" && clientOptions.tokenSource == nil {
+		return nil, fmt.Errorf("%w: WithQuotaProject requires WithTokenSource, API keys are billed to their own project", ErrInvalidOptions)
+	}
+	if clientOptions.apiKey == "" && clientOptions.tokenSource == nil {
+		clientOptions.apiKey = apiKeyFromEnv()
+		if clientOptions.apiKey == "" {
+			return nil, ErrMissingAPIKey
+		}
+	}
+	if err := clientOptions.validate(); err != nil {
+		return nil, err
+	}
+
+	if clientOptions.proxy != "" && clientOptions.httpClient == http.DefaultClient {
+		clientOptions.httpClient = &http.Client{Transport: clientOptions.apiTransport()}
+	}
+
 	gi := &GoogleAI{
-		opts: clientOptions,
+		opts:    clientOptions,
+		breaker: newCircuitBreaker(clientOptions.circuitBreakerThreshold, clientOptions.circuitBreakerCooldown),
+	}
+	if clientOptions.maxConcurrentRequests > 0 {
+		gi.sem = make(chan struct{}, clientOptions.maxConcurrentRequests)
 	}
 
-	client, err := genai.NewClient(ctx, option.WithAPIKey(clientOptions.apiKey))
+	client, err := newGenaiClient(ctx, &clientOptions)
 	if err != nil {
 		return gi, err
 	}
@@ -62,85 +139,497 @@ func NewGoogleAI(ctx context.Context, opts ...Option) (*GoogleAI, error) {
 	return gi, nil
 }
 
+// newGenaiClient creates a genai client configured with opts. The
+// authenticated transport is built here, on top of one recording the request
+// IDs of responses.
+func newGenaiClient(ctx context.Context, opts *options) (*genai.Client, error) {
+	transport, err := htransport.NewTransport(ctx, requestIDTransport{base: opts.apiTransport()}, opts.clientOptions()...)
+	if err != nil {
+		return nil, err
+	}
+	clientOptions := []option.ClientOption{option.WithHTTPClient(&http.Client{Transport: transport})}
+	if opts.endpoint != "" {
+		clientOptions = append(clientOptions, option.WithEndpoint(opts.endpoint))
+	}
+	return genai.NewClient(ctx, clientOptions...)
+}
+
+// UpdateAPIKey replaces the API key of the client, e.g. to rotate it without
+// downtime. The underlying genai client is rebuilt with the new key and
+// swapped in atomically: calls made after UpdateAPIKey returns use the new
+// key, while calls in flight finish with the old one. The old genai client
+// isn't closed, since closing it would break the calls in flight; it is
+// released once they are done. Clients authenticated with WithTokenSource
+// can't switch to an API key.
+func (g *GoogleAI) UpdateAPIKey(ctx context.Context, newKey string) error {
+	if newKey == "" {
+		return ErrMissingAPIKey
+	}
+
+	g.clientMu.Lock()
+	defer g.clientMu.Unlock()
+
+	if g.opts.tokenSource != nil {
+		return fmt.Errorf("%w: can't update the API key of a client using a token source", ErrInvalidOptions)
+	}
+	opts := g.opts
+	opts.apiKey = newKey
+	client, err := newGenaiClient(ctx, &opts)
+	if err != nil {
+		return err
+	}
+	g.client = client
+	g.opts.apiKey = newKey
+	return nil
+}
+
+// genaiClient returns the current genai client.
+func (g *GoogleAI) genaiClient() *genai.Client {
+	g.clientMu.RLock()
+	defer g.clientMu.RUnlock()
+	return g.client
+}
+
+// apiKeyFromEnv returns the value of the first non-empty API key environment
+// variable, or an empty string if none is set.
+func apiKeyFromEnv() string {
+	for _, name := range apiKeyEnvVarNames {
+		if key := os.Getenv(name); key != "" {
+			return key
+		}
+	}
+	return ""
+}
+
+// acquire blocks until a request slot is available when the number of
+// concurrent requests is limited with WithMaxConcurrentRequests, or until ctx
+// is done. Every successful acquire must be followed by a release.
+func (g *GoogleAI) acquire(ctx context.Context) error {
+	if g.sem == nil {
+		return nil
+	}
+	select {
+	case g.sem <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// release frees the request slot taken by acquire.
+func (g *GoogleAI) release() {
+	if g.sem != nil {
+		<-g.sem
+	}
+}
+
 // GenerateContent calls the LLM with the provided parts.
 func (g *GoogleAI) GenerateContent(ctx context.Context, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
+	if len(messages) == 0 {
+		return nil, ErrNoMessages
+	}
+	for i, mc := range messages {
+		if len(mc.Parts) == 0 {
+			return nil, fmt.Errorf("%w: message %d (%v) has no parts", ErrEmptyMessage, i, mc.Role)
+		}
+	}
+
+	if err := g.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer g.release()
+
+	opts := g.callOptions(options...)
+	if err := g.opts.checkModelAllowed(opts.Model); err != nil {
+		return nil, err
+	}
+	if err := checkGenerationModel(opts.Model); err != nil {
+		return nil, err
+	}
+	if err := checkToolsSupported(&opts); err != nil {
+		return nil, err
+	}
+	if g.opts.autoTokenLimits {
+		g.applyTokenLimits(ctx, &opts, estimateTokens(messages))
+	}
+
+	ctx, span := g.startSpan(ctx, "googleai.GenerateContent", opts.Model)
+	ctx, requestID := withRequestIDRecorder(ctx)
+	resp, err := g.generateWithFallback(ctx, messages, &opts)
+	requestID.annotate(resp)
+	span.SetAttributes(attrFinishReasons.StringSlice(finishReasons(resp)))
+	if tokens, ok := outputTokens(resp); ok {
+		span.SetAttributes(attrOutputTokens.Int(tokens))
+	}
+	endSpan(span, err)
+	return resp, err
+}
+
+// GenerateSingle is like GenerateContent for the common case of a single
+// candidate, returning the only choice of the response. It fails with
+// ErrNoContentInResponse if there's no choice, and with ErrMultipleChoices if
+// more than one candidate was requested with llms.WithN.
+func (g *GoogleAI) GenerateSingle(ctx context.Context, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentChoice, error) {
+	resp, err := g.GenerateContent(ctx, messages, options...)
+	if err != nil {
+		return nil, err
+	}
+	switch len(resp.Choices) {
+	case 0:
+		return nil, ErrNoContentInResponse
+	case 1:
+		return resp.Choices[0], nil
+	default:
+		return nil, fmt.Errorf("%w: got %d", ErrMultipleChoices, len(resp.Choices))
+	}
+}
+
+// checkToolsSupported verifies that functions are only passed to models known
+// to support them. Models missing from the capability table are let through.
+func checkToolsSupported(opts *llms.CallOptions) error {
+	if len(opts.Functions) == 0 {
+		return nil
+	}
+	if c, ok := Capabilities(opts.Model); !ok || c.Tools {
+		return nil
+	}
+	if supported := modelSupporting(func(c ModelCapabilities) bool { return c.Tools }); supported != "" {
+		return fmt.Errorf("%w: %v; use a model that does, e.g. %v", ErrToolsNotSupported, opts.Model, supported)
+	}
+	return fmt.Errorf("%w: %v; no model supports tools with this client yet", ErrToolsNotSupported, opts.Model)
+}
+
+// GenerateRaw generates content from genai parts using model, skipping the
+// conversion from and to llms types done by GenerateContent. If model is
+// empty, the model from the call options (or the client's default model) is
+// used. Streaming functions in options are ignored.
+func (g *GoogleAI) GenerateRaw(ctx context.Context, model string, parts []genai.Part, options ...llms.CallOption) (*genai.GenerateContentResponse, error) {
+	if err := g.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer g.release()
+
+	opts := g.callOptions(options...)
+	if model != "" {
+		opts.Model = model
+	}
+	if err := g.opts.checkModelAllowed(opts.Model); err != nil {
+		return nil, err
+	}
+	if g.opts.autoTokenLimits {
+		g.applyTokenLimits(ctx, &opts, estimateRawTokens(parts))
+	}
+
+	m := g.generativeModel(opts.Model, &opts)
+
+	var resp *genai.GenerateContentResponse
+	err := g.call(ctx, func() error {
+		var err error
+		resp, err = m.GenerateContent(ctx, parts...)
+		return err
+	})
+	return resp, err
+}
+
+// callOptions returns the call options for a GenerateContent call: the
+// client's defaults with its default call options and then options applied
+// on top. The default max tokens and temperature are skipped when the base
+// generation config sets them.
+func (g *GoogleAI) callOptions(options ...llms.CallOption) llms.CallOptions {
+	options = append(append([]llms.CallOption{}, g.opts.defaultCallOptions...), options...)
+
+	// The base generation config may be set per call with
+	// WithModelParameters, so peek at the options to find it.
+	var peek llms.CallOptions
+	for _, opt := range options {
+		opt(&peek)
+	}
+	base := g.baseGenerationConfig(&peek)
+
 	opts := llms.CallOptions{
-		Model:       g.opts.defaultModel,
-		MaxTokens:   int(g.opts.defaultMaxTokens),
-		Temperature: float64(g.opts.defaultTemperature),
+		Model: g.opts.defaultModel,
+	}
+	// With auto token limits, unset max tokens are filled in later.
+	if base.MaxOutputTokens == nil && !g.opts.autoTokenLimits {
+		opts.MaxTokens = int(g.opts.defaultMaxTokens)
+	}
+	if base.Temperature == nil {
+		opts.Temperature = float64(g.opts.defaultTemperature)
 	}
 	for _, opt := range options {
 		opt(&opts)
 	}
+	return opts
+}
 
-	model := g.client.GenerativeModel(opts.Model)
-	model.SetMaxOutputTokens(int32(opts.MaxTokens))
-	model.SetTemperature(float32(opts.Temperature))
+// generativeModel returns a model configured with the generation config and
+// safety settings for a call with opts.
+func (g *GoogleAI) generativeModel(name string, opts *llms.CallOptions) *genai.GenerativeModel {
+	model := g.genaiClient().GenerativeModel(name)
+	model.GenerationConfig = g.generationConfig(opts)
+	model.SafetySettings = g.safetySettings(opts)
+	return model
+}
 
-	if len(messages) == 1 {
-		theMessage := messages[0]
-		if theMessage.Role != schema.ChatMessageTypeHuman {
-			return nil, fmt.Errorf("got %v message role, want human", theMessage.Role)
-		}
-		return generateFromSingleMessage(ctx, model, theMessage.Parts, &opts)
+// baseGenerationConfig returns the generation config the call options are
+// applied on top of: the one passed with WithModelParameters if any, otherwise
+// the client's.
+func (g *GoogleAI) baseGenerationConfig(opts *llms.CallOptions) genai.GenerationConfig {
+	if params, ok := modelParameters(opts); ok && params.GenerationConfig != nil {
+		return *params.GenerationConfig
 	}
-	return generateFromMessages(ctx, model, messages, &opts)
+	return g.opts.generationConfig
 }
 
-// downloadImageData downloads the content from the given URL and returns it as
-// a *genai.Blob.
-func downloadImageData(url string) (*genai.Blob, error) {
-	resp, err := http.Get(url) //nolint
+// generationConfig merges the call options into the base generation config.
+// It starts from the config passed with WithModelParameters or
+// WithGenerationConfig and applies the non-zero call options on top of it.
+// Max tokens and temperature are always applied when the config leaves them
+// unset, so that an explicit zero temperature is honored.
+func (g *GoogleAI) generationConfig(opts *llms.CallOptions) genai.GenerationConfig {
+	cfg := g.baseGenerationConfig(opts)
+
+	if opts.MaxTokens != 0 || cfg.MaxOutputTokens == nil {
+		cfg.SetMaxOutputTokens(int32(opts.MaxTokens))
+	}
+	if opts.Temperature != 0 || cfg.Temperature == nil {
+		cfg.SetTemperature(float32(opts.Temperature))
+	}
+	if opts.TopP != 0 {
+		cfg.SetTopP(float32(opts.TopP))
+	}
+	if opts.TopK != 0 {
+		cfg.SetTopK(int32(opts.TopK))
+	}
+	if opts.N != 0 {
+		cfg.SetCandidateCount(int32(opts.N))
+	}
+	if len(opts.StopWords) > 0 {
+		cfg.StopSequences = opts.StopWords
+	}
+	return cfg
+}
+
+// ResponseToMessage converts the first choice of a GenerateContent response
+// into an AI message, so it can be appended to the message history for the
+// next GenerateContent call in a multi-turn conversation.
+func (g *GoogleAI) ResponseToMessage(resp *llms.ContentResponse) llms.MessageContent {
+	msg := llms.MessageContent{
+		Role: schema.ChatMessageTypeAI,
+	}
+	if resp == nil || len(resp.Choices) == 0 {
+		return msg
+	}
+	if content := resp.Choices[0].Content; content != "" {
+		msg.Parts = append(msg.Parts, llms.TextContent{Text: content})
+	}
+	return msg
+}
+
+// supportedImageFormats are the image formats Gemini accepts, as subtypes of
+// the image media type.
+var supportedImageFormats = []string{"png", "jpeg", "webp", "heic", "heif"} //nolint:gochecknoglobals
+
+// imageFormatAliases maps nonstandard image subtypes that servers send to the
+// supported formats they stand for.
+var imageFormatAliases = map[string]string{ //nolint:gochecknoglobals
+	"x-png": "png",
+	"jpg":   "jpeg",
+	"pjpeg": "jpeg",
+}
+
+// downloadImageData downloads the content from the given URL with client and
+// returns it as a *genai.Blob. Content that isn't an image in a supported
+// format, according to its Content-Type, fails with ErrInvalidMimeType, and
+// content larger than maxInlineDataSize fails with ErrInlineDataTooLarge.
+// Nonstandard subtypes such as image/x-png are normalized.
+func downloadImageData(ctx context.Context, client *http.Client, url string) (*genai.Blob, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch image from url: %w", err)
+	}
+	resp, err := client.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch image from url: %w", err)
 	}
 	defer resp.Body.Close()
 
-	urlData, err := io.ReadAll(resp.Body)
+	// Reject anything but images, such as the HTML of an error or login page,
+	// rather than passing it off as an image.
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %q at %v: %w", ErrInvalidMimeType, resp.Header.Get("Content-Type"), url, err)
+	}
+	format, ok := strings.CutPrefix(mediaType, "image/")
+	if !ok || format == "" {
+		return nil, fmt.Errorf("%w: %v at %v is not an image", ErrInvalidMimeType, mediaType, url)
+	}
+	if alias, ok := imageFormatAliases[format]; ok {
+		format = alias
+	}
+	if !slices.Contains(supportedImageFormats, format) {
+		return nil, fmt.Errorf("%w: image format %v at %v is not supported; supported formats are %v",
+			ErrInvalidMimeType, format, url, strings.Join(supportedImageFormats, ", "))
+	}
+
+	urlData, err := io.ReadAll(io.LimitReader(resp.Body, maxInlineDataSize+1))
 	if err != nil {
 		return nil, fmt.Errorf("failed to read image bytes: %w", err)
 	}
+	if len(urlData) > maxInlineDataSize {
+		return nil, fmt.Errorf("%w: image at %v exceeds the limit of %d bytes", ErrInlineDataTooLarge, url, maxInlineDataSize)
+	}
+
+	blob := genai.ImageData(format, urlData)
+
+	return &blob, nil
+}
 
-	mimeType := resp.Header.Get("Content-Type")
+// maxConcurrentImageDownloads is the maximum number of images downloaded
+// concurrently for the ImageURLContent parts of a message.
+const maxConcurrentImageDownloads = 4
 
-	// The convenience function genai.ImageData requires just the right part of
-	// the mime type, so we need to parse it
-	parts := strings.Split(mimeType, "/")
+// downloadImages downloads the images of the ImageURLContent parts at the
+// given indices into the corresponding elements of out, at most
+// maxConcurrentImageDownloads at a time. Once a download fails, the remaining
+// ones are canceled and the error of the first failed part is returned.
+func (g *GoogleAI) downloadImages(ctx context.Context, parts []llms.ContentPart, indices []int, out []genai.Part) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
-	if len(parts) != 2 { //nolint
-		return nil, ErrInvalidMimeType
+	errs := make([]error, len(parts))
+	sem := make(chan struct{}, maxConcurrentImageDownloads)
+	var wg sync.WaitGroup
+	for _, i := range indices {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			errs[i] = ctx.Err()
+			continue
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer func() { <-sem; wg.Done() }()
+			blob, err := downloadImageData(ctx, g.opts.httpClient, parts[i].(llms.ImageURLContent).URL) //nolint:forcetypeassert
+			if err != nil {
+				errs[i] = err
+				cancel()
+				return
+			}
+			out[i] = blob
+		}(i)
 	}
+	wg.Wait()
 
-	blob := genai.ImageData(parts[1], urlData)
+	// Report the error that caused the cancellation rather than the
+	// cancellation errors of the downloads it aborted, unless ctx itself was
+	// done.
+	var firstErr error
+	for _, err := range errs {
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, context.Canceled) {
+			return err
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
 
-	return &blob, nil
+// convertResponse converts a non-streamed response. A response without
+// candidates is handled according to the client's EmptyResponsePolicy.
+func (g *GoogleAI) convertResponse(resp *genai.GenerateContentResponse) (*llms.ContentResponse, error) {
+	if len(resp.Candidates) > 0 {
+		return g.convertCandidates(resp.Candidates)
+	}
+	if g.opts.emptyResponsePolicy != EmptyResponseReturnEmpty {
+		return nil, ErrNoContentInResponse
+	}
+
+	metadata := make(map[string]any)
+	if resp.PromptFeedback != nil {
+		metadata[BLOCK_REASON] = resp.PromptFeedback.BlockReason.String()
+		metadata[SAFETY] = resp.PromptFeedback.SafetyRatings
+	}
+	return &llms.ContentResponse{
+		Choices: []*llms.ContentChoice{{GenerationInfo: metadata}},
+	}, nil
+}
+
+// InlineData is binary data returned by the model, reported in
+// GenerationInfo[INLINE_DATA].
+type InlineData struct {
+	// MIMEType is the MIME type of the data.
+	MIMEType string
+	// Data is the base64-encoded data.
+	Data string
 }
 
 // convertCandidates converts a sequence of genai.Candidate to a response.
-func convertCandidates(candidates []*genai.Candidate) (*llms.ContentResponse, error) {
+func (g *GoogleAI) convertCandidates(candidates []*genai.Candidate) (*llms.ContentResponse, error) {
 	var contentResponse llms.ContentResponse
 
 	for _, candidate := range candidates {
 		buf := strings.Builder{}
+		var inlineData []InlineData
 
-		for _, part := range candidate.Content.Parts {
-			if v, ok := part.(genai.Text); ok {
+		var parts []genai.Part
+		if candidate.Content != nil {
+			parts = candidate.Content.Parts
+		}
+		for _, part := range parts {
+			switch v := part.(type) {
+			case genai.Text:
 				_, err := buf.WriteString(string(v))
 				if err != nil {
 					return nil, err
 				}
-			} else {
+			case genai.Blob:
+				inlineData = append(inlineData, InlineData{
+					MIMEType: v.MIMEType,
+					Data:     base64.StdEncoding.EncodeToString(v.Data),
+				})
+			default:
 				return nil, ErrUnknownPartInResponse
 			}
 		}
 
 		metadata := make(map[string]any)
-		metadata[CITATIONS] = candidate.CitationMetadata
-		metadata[SAFETY] = candidate.SafetyRatings
+		if len(inlineData) > 0 {
+			metadata[INLINE_DATA] = inlineData
+		}
+		if len(parts) == 0 && candidate.FinishReason == genai.FinishReasonStop {
+			metadata[EMPTY_ANSWER] = true
+		}
+		if candidate.TokenCount > 0 {
+			metadata[OUTPUT_TOKENS] = int(candidate.TokenCount)
+		}
+		if !g.opts.omitResponseMetadata {
+			metadata[CITATIONS] = candidate.CitationMetadata
+		}
+		if !g.opts.omitResponseMetadata && g.includeSafetyRatings(candidate.SafetyRatings) {
+			metadata[SAFETY] = candidate.SafetyRatings
+			if g.opts.safetyReportThreshold != genai.HarmProbabilityUnspecified {
+				metadata[SAFETY] = filterSafetyRatings(candidate.SafetyRatings, g.opts.safetyReportThreshold)
+				metadata[SAFETY_ALL] = candidate.SafetyRatings
+			}
+		}
+		if g.opts.citationSegments {
+			metadata[SEGMENTS] = citationSegments(buf.String(), candidate.CitationMetadata)
+		}
+
+		content := buf.String()
+		if g.opts.treatSafetyBlockAsEmpty && candidate.FinishReason == genai.FinishReasonSafety {
+			content = ""
+			metadata[BLOCK_REASON] = candidate.FinishReason.String()
+		}
 
 		contentResponse.Choices = append(contentResponse.Choices,
 			&llms.ContentChoice{
-				Content:        buf.String(),
+				Content:        content,
 				StopReason:     candidate.FinishReason.String(),
 				GenerationInfo: metadata,
 			})
@@ -148,15 +637,235 @@ func convertCandidates(candidates []*genai.Candidate) (*llms.ContentResponse, er
 	return &contentResponse, nil
 }
 
-// CreateEmbedding creates embeddings from texts.
+// includeSafetyRatings reports whether ratings are to be included in the
+// response, according to WithSafetyRatingsOnlyIfFlagged.
+func (g *GoogleAI) includeSafetyRatings(ratings []*genai.SafetyRating) bool {
+	if !g.opts.safetyOnlyIfFlagged {
+		return true
+	}
+	for _, rating := range ratings {
+		if rating.Blocked || g.opts.safetyFlagThreshold != genai.HarmProbabilityUnspecified && rating.Probability >= g.opts.safetyFlagThreshold {
+			return true
+		}
+	}
+	return false
+}
+
+// filterSafetyRatings returns the ratings whose probability is at or above
+// threshold.
+func filterSafetyRatings(ratings []*genai.SafetyRating, threshold genai.HarmProbability) []*genai.SafetyRating {
+	filtered := make([]*genai.SafetyRating, 0, len(ratings))
+	for _, rating := range ratings {
+		if rating.Probability >= threshold {
+			filtered = append(filtered, rating)
+		}
+	}
+	return filtered
+}
+
+// IsTruncated reports whether generation of choice stopped because it reached
+// the maximum number of output tokens, e.g. to offer the user to continue.
+func IsTruncated(choice *llms.ContentChoice) bool {
+	return choice != nil && choice.StopReason == genai.FinishReasonMaxTokens.String()
+}
+
+// IsEmptyAnswer reports whether choice is a successful, but empty, answer:
+// the model finished normally without producing any content. This tells it
+// apart from an empty choice reporting a blocked response, see
+// WithTreatSafetyBlockAsEmpty and EmptyResponseReturnEmpty.
+func IsEmptyAnswer(choice *llms.ContentChoice) bool {
+	if choice == nil {
+		return false
+	}
+	empty, _ := choice.GenerationInfo[EMPTY_ANSWER].(bool)
+	return empty
+}
+
+// MaxHarmProbability returns the highest harm probability among the safety
+// ratings of all choices in resp, across all harm categories. Callers can use
+// it to apply their own policy on top of Gemini's binary blocking. When the
+// response was generated with WithSafetyReportThreshold, the complete ratings
+// in GenerationInfo[SAFETY_ALL] are scanned.
+func MaxHarmProbability(resp *llms.ContentResponse) genai.HarmProbability {
+	maxProbability := genai.HarmProbabilityUnspecified
+	if resp == nil {
+		return maxProbability
+	}
+	for _, choice := range resp.Choices {
+		ratings, ok := choice.GenerationInfo[SAFETY_ALL].([]*genai.SafetyRating)
+		if !ok {
+			ratings, _ = choice.GenerationInfo[SAFETY].([]*genai.SafetyRating)
+		}
+		for _, rating := range ratings {
+			if rating.Probability > maxProbability {
+				maxProbability = rating.Probability
+			}
+		}
+	}
+	return maxProbability
+}
+
+// embeddingModel returns the embedding model called name, configured for
+// taskType.
+func (g *GoogleAI) embeddingModel(name string, taskType genai.TaskType) embeddingModel {
+	if g.newEmbeddingModel != nil {
+		return g.newEmbeddingModel(name, taskType)
+	}
+	em := g.genaiClient().EmbeddingModel(name)
+	em.TaskType = taskType
+	return em
+}
+
+// CreateEmbedding creates embeddings from texts. On failure, the embeddings
+// created so far are returned along with the error; see
+// CreateEmbeddingWithOptions.
 func (g *GoogleAI) CreateEmbedding(ctx context.Context, texts []string) ([][]float32, error) {
-	em := g.client.EmbeddingModel(g.opts.defaultEmbeddingModel)
+	return g.CreateEmbeddingWithOptions(ctx, texts)
+}
+
+// CreateEmbeddingWithOptions creates embeddings from texts, like
+// CreateEmbedding, with per-call options for the embedding model, task type
+// and title.
+//
+// Texts are embedded in order. If embedding fails partway, e.g. because ctx
+// is canceled, the embeddings of the texts before the failed one are returned
+// along with the error, so that the caller can resume from texts[len(results)].
+// If ctx is done, the error is ctx.Err().
+func (g *GoogleAI) CreateEmbeddingWithOptions(ctx context.Context, texts []string, options ...EmbeddingOption) ([][]float32, error) {
+	if err := g.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer g.release()
+
+	opts, err := g.embeddingOptions(options...)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, span := g.startSpan(ctx, "googleai.CreateEmbedding", opts.model)
+	span.SetAttributes(attrInputCount.Int(len(texts)))
+	results, err := g.embedTexts(ctx, g.embeddingModelFor(opts), texts)
+	endSpan(span, err)
+	return results, err
+}
 
+// embeddingOptions returns the options for an embedding call with options,
+// and checks that the model may be used for embeddings.
+func (g *GoogleAI) embeddingOptions(options ...EmbeddingOption) (embeddingOptions, error) {
+	opts := embeddingOptions{
+		model: g.opts.defaultEmbeddingModel,
+	}
+	for _, opt := range options {
+		opt(&opts)
+	}
+	if err := g.opts.checkModelAllowed(opts.model); err != nil {
+		return opts, err
+	}
+	if err := checkEmbeddingModel(opts.model); err != nil {
+		return opts, err
+	}
+	if opts.title != "" {
+		switch opts.taskType {
+		case genai.TaskTypeUnspecified:
+			opts.taskType = genai.TaskTypeRetrievalDocument
+		case genai.TaskTypeRetrievalDocument:
+		default:
+			return opts, fmt.Errorf("%w: an embedding title requires the %v task type, got %v",
+				ErrInvalidOptions, genai.TaskTypeRetrievalDocument, opts.taskType)
+		}
+	}
+	return opts, nil
+}
+
+// embeddingModelFor returns the embedding model for a call with opts.
+func (g *GoogleAI) embeddingModelFor(opts embeddingOptions) embeddingModel {
+	em := g.embeddingModel(opts.model, opts.taskType)
+	if opts.title != "" {
+		return titledEmbeddingModel{embeddingModel: em, title: opts.title}
+	}
+	return em
+}
+
+// titledEmbeddingModel embeds content with a title.
+type titledEmbeddingModel struct {
+	embeddingModel
+	title string
+}
+
+func (m titledEmbeddingModel) EmbedContent(ctx context.Context, parts ...genai.Part) (*genai.EmbedContentResponse, error) {
+	return m.EmbedContentWithTitle(ctx, m.title, parts...)
+}
+
+// embeddingError returns the error to report for a failed embedding call:
+// ctx.Err() if ctx is done, since the call then most likely failed because of
+// it, and err otherwise.
+func embeddingError(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	return err
+}
+
+// embedTexts embeds texts with em, one at a time. On failure, the embeddings
+// created so far are returned along with the error.
+func (g *GoogleAI) embedTexts(ctx context.Context, em embeddingModel, texts []string) ([][]float32, error) {
 	results := make([][]float32, 0, len(texts))
 	for _, t := range texts {
-		res, err := em.EmbedContent(ctx, genai.Text(t))
+		values, _, err := g.embedOne(ctx, em, t)
+		if err != nil {
+			return results, embeddingError(ctx, err)
+		}
+		results = append(results, values)
+	}
+
+	return results, nil
+}
+
+// embedText embeds a single text with em.
+func (g *GoogleAI) embedText(ctx context.Context, em embeddingModel, text string) ([]float32, error) {
+	var res *genai.EmbedContentResponse
+	err := g.call(ctx, func() error {
+		var err error
+		res, err = em.EmbedContent(ctx, genai.Text(text))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res.Embedding.Values, nil
+}
+
+// EmbedDocumentsWithMetadata creates retrieval embeddings for docs. The title
+// of each document is read from its metadata under titleKey and passed along
+// with the page content, which improves embedding quality for retrieval.
+// Documents without a (string) title in their metadata are embedded without
+// one. Like CreateEmbedding, it returns the embeddings created so far along
+// with the error on failure.
+func (g *GoogleAI) EmbedDocumentsWithMetadata(ctx context.Context, docs []schema.Document, titleKey string) ([][]float32, error) {
+	if err := g.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer g.release()
+
+	if err := g.opts.checkModelAllowed(g.opts.defaultEmbeddingModel); err != nil {
+		return nil, err
+	}
+	if err := checkEmbeddingModel(g.opts.defaultEmbeddingModel); err != nil {
+		return nil, err
+	}
+	em := g.embeddingModel(g.opts.defaultEmbeddingModel, genai.TaskTypeRetrievalDocument)
+
+	results := make([][]float32, 0, len(docs))
+	for _, doc := range docs {
+		title, _ := doc.Metadata[titleKey].(string)
+		var res *genai.EmbedContentResponse
+		err := g.call(ctx, func() error {
+			var err error
+			res, err = em.EmbedContentWithTitle(ctx, title, genai.Text(doc.PageContent))
+			return err
+		})
 		if err != nil {
-			return results, err
+			return results, embeddingError(ctx, err)
 		}
 		results = append(results, res.Embedding.Values)
 	}
@@ -165,9 +874,13 @@ func (g *GoogleAI) CreateEmbedding(ctx context.Context, texts []string) ([][]flo
 }
 
 // convertParts converts between a sequence of langchain parts and genai parts.
-func convertParts(parts []llms.ContentPart) ([]genai.Part, error) {
-	convertedParts := make([]genai.Part, 0, len(parts))
-	for _, part := range parts {
+// The images of ImageURLContent parts are downloaded concurrently; the
+// converted parts are always in the order of parts, however the downloads
+// finish, so that the interleaving of images and text is preserved.
+func (g *GoogleAI) convertParts(ctx context.Context, parts []llms.ContentPart) ([]genai.Part, error) {
+	convertedParts := make([]genai.Part, len(parts))
+	var imageURLParts []int
+	for i, part := range parts {
 		var out genai.Part
 		var err error
 
@@ -175,149 +888,557 @@ func convertParts(parts []llms.ContentPart) ([]genai.Part, error) {
 		case llms.TextContent:
 			out = genai.Text(p.Text)
 		case llms.BinaryContent:
-			out = genai.Blob{MIMEType: p.MIMEType, Data: p.Data}
+			out, err = binaryContentBlob(p)
+		case llms.ReaderContent:
+			out, err = readerContentBlob(p)
+			if err != nil {
+				err = fmt.Errorf("part %d: %w", i, err)
+			}
 		case llms.ImageURLContent:
-			out, err = downloadImageData(p.URL)
+			imageURLParts = append(imageURLParts, i)
+			continue
 		}
 		if err != nil {
 			return nil, err
 		}
 
-		convertedParts = append(convertedParts, out)
+		convertedParts[i] = out
+	}
+	if len(imageURLParts) > 0 {
+		if err := g.downloadImages(ctx, parts, imageURLParts, convertedParts); err != nil {
+			return nil, err
+		}
 	}
 	return convertedParts, nil
 }
 
+// binaryContentBlob converts BinaryContent to a genai.Blob. If the content has
+// no MIME type, it is detected from the data, without parameters such as the
+// charset, which Gemini doesn't accept.
+func binaryContentBlob(content llms.BinaryContent) (genai.Blob, error) {
+	mimeType := content.MIMEType
+	if mimeType == "" {
+		// DetectContentType falls back to application/octet-stream when it
+		// can't recognize the data, which Gemini doesn't accept.
+		detected := http.DetectContentType(content.Data)
+		if detected == "application/octet-stream" {
+			return genai.Blob{}, fmt.Errorf("%w: can't detect mime type of binary content", ErrInvalidMimeType)
+		}
+		var err error
+		if mimeType, _, err = mime.ParseMediaType(detected); err != nil {
+			return genai.Blob{}, fmt.Errorf("%w: %v of binary content", ErrInvalidMimeType, detected)
+		}
+	}
+	return genai.Blob{MIMEType: mimeType, Data: content.Data}, nil
+}
+
+// readerContentBlob reads ReaderContent into a genai.Blob. At most
+// maxInlineDataSize bytes are read; larger content fails with
+// ErrInlineDataTooLarge.
+func readerContentBlob(content llms.ReaderContent) (genai.Blob, error) {
+	data, err := io.ReadAll(io.LimitReader(content.Reader, maxInlineDataSize+1))
+	if err != nil {
+		return genai.Blob{}, fmt.Errorf("failed to read content: %w", err)
+	}
+	if len(data) > maxInlineDataSize {
+		return genai.Blob{}, fmt.Errorf("%w: content exceeds the limit of %d bytes", ErrInlineDataTooLarge, maxInlineDataSize)
+	}
+	return binaryContentBlob(llms.BinaryContent{MIMEType: content.MIMEType, Data: data})
+}
+
 // convertContent converts between a langchain MessageContent and genai content.
-func convertContent(content llms.MessageContent) (*genai.Content, error) {
-	parts, err := convertParts(content.Parts)
+func (g *GoogleAI) convertContent(ctx context.Context, content llms.MessageContent) (*genai.Content, error) {
+	parts, err := g.convertParts(ctx, content.Parts)
 	if err != nil {
 		return nil, err
 	}
 
-	c := &genai.Content{
-		Parts: parts,
+	role, err := geminiRole(content.Role)
+	if err != nil {
+		return nil, err
 	}
+	return &genai.Content{Role: role, Parts: parts}, nil
+}
 
-	switch content.Role {
-	case schema.ChatMessageTypeSystem:
-		return nil, ErrSystemRoleNotSupported
+// geminiRole maps the role of a langchaingo message to the Gemini role of
+// its content:
+//
+//   - human and generic messages are user turns (RoleUser);
+//   - AI messages are model turns (RoleModel);
+//   - system messages aren't supported (ErrSystemRoleNotSupported), since the
+//     genai version this client is built on has no system instructions; with
+//     WithSystemMessageFallback, they are merged into the first user message
+//     before conversion;
+//   - function and tool result messages, which Gemini takes as function
+//     responses in a user turn, aren't supported either, since that genai
+//     version has no function calling.
+func geminiRole(role schema.ChatMessageType) (string, error) {
+	switch role {
+	case schema.ChatMessageTypeHuman, schema.ChatMessageTypeGeneric:
+		return RoleUser, nil
 	case schema.ChatMessageTypeAI:
-		c.Role = RoleModel
-	case schema.ChatMessageTypeHuman:
-		c.Role = RoleUser
-	case schema.ChatMessageTypeGeneric:
-		c.Role = RoleUser
-	case schema.ChatMessageTypeFunction:
-		fallthrough
+		return RoleModel, nil
+	case schema.ChatMessageTypeSystem:
+		return "", ErrSystemRoleNotSupported
 	default:
-		return nil, fmt.Errorf("role %v not supported", content.Role)
+		return "", fmt.Errorf("role %v not supported", role)
 	}
-
-	return c, nil
 }
 
-// generateFromSingleMessage generates content from the parts of a single
-// message.
-func generateFromSingleMessage(ctx context.Context, model *genai.GenerativeModel, parts []llms.ContentPart, opts *llms.CallOptions) (*llms.ContentResponse, error) {
-	convertedParts, err := convertParts(parts)
-	if err != nil {
-		return nil, err
-	}
+// maxInlineDataSize is the maximum total size of inline data in a request.
+const maxInlineDataSize = 20 << 20
 
-	if opts.StreamingFunc == nil {
-		// When no streaming is requested, just call GenerateContent and return
-		// the complete response with a list of candidates.
-		resp, err := model.GenerateContent(ctx, convertedParts...)
-		if err != nil {
-			return nil, err
+// checkInlineDataSize verifies that the total size of the inline data in
+// contents doesn't exceed what the API accepts in a single request.
+func checkInlineDataSize(contents ...*genai.Content) error {
+	size := 0
+	for _, content := range contents {
+		for _, part := range content.Parts {
+			switch p := part.(type) {
+			case genai.Blob:
+				size += len(p.Data)
+			case *genai.Blob:
+				size += len(p.Data)
+			}
 		}
+	}
+	if size > maxInlineDataSize {
+		return fmt.Errorf("%w: %d bytes of inline data exceed the limit of %d bytes; "+
+			"consider uploading large files with the File API instead", ErrInlineDataTooLarge, size, maxInlineDataSize)
+	}
+	return nil
+}
 
-		if len(resp.Candidates) == 0 {
-			return nil, ErrNoContentInResponse
-		}
-		return convertCandidates(resp.Candidates)
+// defaultMaxPartsPerRequest is the default maximum number of content parts in
+// a request, the API's limit of images per request.
+const defaultMaxPartsPerRequest = 3000
+
+// checkPartCount verifies that contents don't have more than maxParts parts
+// in total, unless maxParts is 0.
+func checkPartCount(maxParts int, contents ...*genai.Content) error {
+	if maxParts == 0 {
+		return nil
+	}
+	n := 0
+	for _, content := range contents {
+		n += len(content.Parts)
 	}
-	iter := model.GenerateContentStream(ctx, convertedParts...)
-	return convertAndStreamFromIterator(ctx, iter, opts)
+	if n > maxParts {
+		return fmt.Errorf("%w: %d parts exceed the limit of %d; see WithMaxPartsPerRequest", ErrTooManyParts, n, maxParts)
+	}
+	return nil
 }
 
-func generateFromMessages(ctx context.Context, model *genai.GenerativeModel, messages []llms.MessageContent, opts *llms.CallOptions) (*llms.ContentResponse, error) {
-	history := make([]*genai.Content, 0, len(messages))
+// withSingleMessageRole returns messages, with the role of a single message
+// without a role set to the client's single message role.
+func (g *GoogleAI) withSingleMessageRole(messages []llms.MessageContent) []llms.MessageContent {
+	if len(messages) == 1 && messages[0].Role == "" {
+		return []llms.MessageContent{{Role: g.opts.singleMessageRole, Parts: messages[0].Parts}}
+	}
+	return messages
+}
+
+// convertMessages converts messages to the contents of a request, validating
+// them. If messages has a single message without a role, it is taken to have
+// the client's single message role. The contents are converted once per
+// request, so that they can be reused for every model of a fallback chain:
+// reader contents can only be read once.
+func (g *GoogleAI) convertMessages(ctx context.Context, messages []llms.MessageContent) ([]*genai.Content, error) {
+	messages = g.withSingleMessageRole(messages)
+	if err := checkLastMessageFromUser(messages); err != nil {
+		return nil, err
+	}
+	messages = trimHistory(messages, g.opts.maxHistoryMessages)
+	if g.opts.systemMessageFallback {
+		messages = prependSystemMessages(messages)
+	}
+
+	contents := make([]*genai.Content, 0, len(messages))
 	for _, mc := range messages {
-		content, err := convertContent(mc)
+		content, err := g.convertContent(ctx, mc)
 		if err != nil {
 			return nil, err
 		}
-		history = append(history, content)
+		contents = append(contents, content)
+	}
+	if err := checkInlineDataSize(contents...); err != nil {
+		return nil, err
+	}
+	if err := checkPartCount(g.opts.maxPartsPerRequest, contents...); err != nil {
+		return nil, err
+	}
+	return contents, nil
+}
+
+// generate generates content from contents, as converted by convertMessages.
+// The last content is the request, the ones before it are sent as the chat
+// history.
+func (g *GoogleAI) generate(ctx context.Context, model *genai.GenerativeModel, contents []*genai.Content, opts *llms.CallOptions) (*llms.ContentResponse, error) {
+	if c, ok := Capabilities(opts.Model); ok && !c.MultiTurn && len(contents) > 1 {
+		return nil, fmt.Errorf("%w: %v", ErrMultiTurnNotSupported, opts.Model)
 	}
 
 	// Given N total messages, genai's chat expects the first N-1 messages as
 	// history and the last message as the actual request.
-	n := len(history)
-	reqContent := history[n-1]
-	history = history[:n-1]
+	n := len(contents)
+	reqContent := contents[n-1]
+	history := contents[:n-1]
+
+	if opts.StreamingFunc == nil && streamingFuncN(opts) == nil {
+		var resp *genai.GenerateContentResponse
+		err := g.call(ctx, func() error {
+			var err error
+			resp, err = sendMessage(ctx, model, history, reqContent)
+			return err
+		})
+		if resp, ok := g.blockedResponse(err); ok {
+			return resp, nil
+		}
+		if err != nil {
+			return nil, err
+		}
 
-	if reqContent.Role != RoleUser {
-		return nil, fmt.Errorf("got %v message role, want user/human", reqContent.Role)
+		return g.convertResponse(resp)
+	}
+	if err := g.breaker.allow(); err != nil {
+		return nil, err
 	}
+	// The stream is canceled once it's no longer read, so that aborting it
+	// doesn't keep the connection to the API open.
+	streamCtx, cancelStream := context.WithCancel(ctx)
+	defer cancelStream()
+	iter := &apiStream{
+		start:  g.timeNow(),
+		cancel: cancelStream,
+	}
+	iter.responseIterator = sendMessageStream(streamCtx, model, history, reqContent)
+	return g.convertAndStreamFromIterator(ctx, iter, opts)
+}
 
+// sendMessage sends reqContent to model, with history as the preceding chat
+// turns. Without history, the request is made without a chat session, which
+// would limit the response to a single candidate.
+func sendMessage(ctx context.Context, model *genai.GenerativeModel, history []*genai.Content, reqContent *genai.Content) (*genai.GenerateContentResponse, error) {
+	if len(history) == 0 {
+		return model.GenerateContent(ctx, reqContent.Parts...)
+	}
 	session := model.StartChat()
 	session.History = history
+	return session.SendMessage(ctx, reqContent.Parts...)
+}
 
-	if opts.StreamingFunc == nil {
-		resp, err := session.SendMessage(ctx, reqContent.Parts...)
-		if err != nil {
-			return nil, err
+// sendMessageStream is the streaming variant of sendMessage.
+func sendMessageStream(ctx context.Context, model *genai.GenerativeModel, history []*genai.Content, reqContent *genai.Content) responseIterator {
+	if len(history) == 0 {
+		return model.GenerateContentStream(ctx, reqContent.Parts...)
+	}
+	session := model.StartChat()
+	session.History = history
+	return session.SendMessageStream(ctx, reqContent.Parts...)
+}
+
+// trimHistory drops the oldest non-system messages so that at most
+// maxMessages of them remain. System messages are all kept, moved to the
+// front of the trimmed history in their original order. If the oldest
+// remaining message would be a model turn, it is dropped as well, since chat
+// history has to start with a user turn. A maxMessages of 0 means no limit.
+func trimHistory(messages []llms.MessageContent, maxMessages int) []llms.MessageContent {
+	if maxMessages <= 0 {
+		return messages
+	}
+
+	var system []llms.MessageContent
+	var turns []llms.MessageContent
+	for _, mc := range messages {
+		if mc.Role == schema.ChatMessageTypeSystem {
+			system = append(system, mc)
+		} else {
+			turns = append(turns, mc)
 		}
+	}
+	if len(turns) <= maxMessages {
+		return messages
+	}
+
+	turns = turns[len(turns)-maxMessages:]
+	for len(turns) > 1 && turns[0].Role == schema.ChatMessageTypeAI {
+		turns = turns[1:]
+	}
+	return append(system, turns...)
+}
 
-		if len(resp.Candidates) == 0 {
-			return nil, ErrNoContentInResponse
+// prependSystemMessages removes the system messages from messages and
+// prepends their parts, in order, to the first user message, as described for
+// WithSystemMessageFallback. messages isn't modified.
+func prependSystemMessages(messages []llms.MessageContent) []llms.MessageContent {
+	var system []llms.ContentPart
+	turns := make([]llms.MessageContent, 0, len(messages))
+	for _, mc := range messages {
+		if mc.Role == schema.ChatMessageTypeSystem {
+			system = append(system, mc.Parts...)
+		} else {
+			turns = append(turns, mc)
+		}
+	}
+	if len(system) == 0 {
+		return messages
+	}
+	for i, mc := range turns {
+		if mc.Role == schema.ChatMessageTypeHuman || mc.Role == schema.ChatMessageTypeGeneric {
+			turns[i].Parts = append(system, mc.Parts...)
+			break
 		}
-		return convertCandidates(resp.Candidates)
 	}
-	iter := session.SendMessageStream(ctx, reqContent.Parts...)
-	return convertAndStreamFromIterator(ctx, iter, opts)
+	return turns
+}
+
+// checkLastMessageFromUser verifies that the final message of a chat sequence
+// is a user turn, which Gemini requires for the request message.
+func checkLastMessageFromUser(messages []llms.MessageContent) error {
+	last := messages[len(messages)-1]
+	switch last.Role {
+	case schema.ChatMessageTypeHuman, schema.ChatMessageTypeGeneric:
+		return nil
+	default:
+		return fmt.Errorf("%w: got %v as the last of %d messages; this is usually caused by "+
+			"a trailing AI message left over from the conversation history", ErrLastMessageNotFromUser, last.Role, len(messages))
+	}
+}
+
+// timeNow returns the current time.
+func (g *GoogleAI) timeNow() time.Time {
+	if g.now != nil {
+		return g.now()
+	}
+	return time.Now()
+}
+
+// responseIterator is implemented by *genai.GenerateContentResponseIterator.
+type responseIterator interface {
+	Next() (*genai.GenerateContentResponse, error)
+}
+
+// apiStream is a responseIterator over a stream from the API, which can be
+// canceled, and whose timing is reported in the response.
+type apiStream struct {
+	responseIterator
+	cancel context.CancelFunc
+	// start is when the stream was requested.
+	start time.Time
+}
+
+// drainStream discards the rest of iter after streaming was aborted. An
+// apiStream is canceled first, so that draining it ends promptly and frees
+// the stream. Errors while draining are ignored.
+func drainStream(iter responseIterator) {
+	if c, ok := iter.(*apiStream); ok {
+		c.cancel()
+	}
+	for {
+		if _, err := iter.Next(); err != nil {
+			return
+		}
+	}
+}
+
+// mergeStreamedCandidate merges src, the candidate of a streamed response
+// chunk, into dst, the candidate accumulated from the chunks before it. Parts
+// are appended in the order they were streamed, whatever their type. A chunk
+// without content, such as a final one only reporting the finish reason, adds
+// no parts, and safety ratings are kept from the last chunk reporting them.
+// Citations are accumulated from all chunks; see mergeStreamedCitations.
+func mergeStreamedCandidate(dst, src *genai.Candidate) {
+	offset := textLength(dst.Content.Parts)
+	if src.Content != nil {
+		dst.Content.Parts = append(dst.Content.Parts, src.Content.Parts...)
+		if src.Content.Role != "" {
+			dst.Content.Role = src.Content.Role
+		}
+	}
+	dst.FinishReason = src.FinishReason
+	if src.SafetyRatings != nil {
+		dst.SafetyRatings = src.SafetyRatings
+	}
+	if src.CitationMetadata != nil {
+		dst.CitationMetadata = mergeStreamedCitations(dst.CitationMetadata, src, offset)
+	}
+	dst.TokenCount += src.TokenCount
+}
+
+// withoutContent returns a copy of the streamed candidate without its content
+// and the citations into it.
+func withoutContent(candidate *genai.Candidate) *genai.Candidate {
+	c := *candidate
+	c.Content = nil
+	c.CitationMetadata = nil
+	return &c
 }
 
 // convertAndStreamFromIterator takes an iterator of GenerateContentResponse
 // and produces a llms.ContentResponse reply from it, while streaming the
 // resulting text into the opts-provided streaming function.
-// Note that this is tricky in the face of multiple
-// candidates, so this code assumes only a single candidate for now.
-func convertAndStreamFromIterator(ctx context.Context, iter *genai.GenerateContentResponseIterator, opts *llms.CallOptions) (*llms.ContentResponse, error) {
-	candidate := &genai.Candidate{
-		Content: &genai.Content{},
+// If the streaming function returns an error, streaming stops and the partial
+// response is returned together with an error wrapping both ErrStreamAborted
+// and the streaming function's error.
+// Multiple candidates are only accepted with a streaming function set with
+// WithStreamingFuncN, which is passed the text of each candidate along with
+// its index; otherwise the stream must have a single candidate.
+// With WithDiscardStreamAggregate, the parts are only streamed, and the
+// response has choices without content.
+// For streams from the API, the time to the first text and the duration of
+// the stream are reported in GenerationInfo[TTFT_MS] and
+// GenerationInfo[STREAM_DURATION_MS].
+func (g *GoogleAI) convertAndStreamFromIterator(ctx context.Context, iter responseIterator, opts *llms.CallOptions) (*llms.ContentResponse, error) {
+	streamFunc := streamingFuncN(opts)
+	multi := streamFunc != nil
+	if !multi {
+		streamFunc = func(ctx context.Context, _ int, chunk []byte) error {
+			return opts.StreamingFunc(ctx, chunk)
+		}
 	}
+
+	// With WithDiscardStreamAggregate, the parts of the candidates are only
+	// streamed; hasParts records which candidates had any.
+	discard := discardStreamAggregate(opts)
+	hasParts := make(map[int]bool)
+
+	// Streams from the API are timed from their request.
+	timedStream, timed := iter.(*apiStream)
+	var firstText time.Time
+
+	// candidates are accumulated by index.
+	candidates := []*genai.Candidate{{Content: &genai.Content{}}}
+	// The circuit breaker was consulted before the stream was opened; record
+	// whether the API failed while streaming.
+	var apiErr, streamErr error
+	defer func() { g.breaker.record(apiErr) }()
+
+	// With WithStreamingBufferSize and WithMinChunkInterval, the text of each
+	// candidate is collected and passed on once it reaches the buffer size and
+	// the interval has passed since it was last passed on, and when the stream
+	// ends.
+	type pending struct {
+		buf       []byte
+		flushedAt time.Time
+	}
+	buffers := make(map[int]*pending)
+	flush := func(index int) error {
+		p := buffers[index]
+		if p == nil || len(p.buf) == 0 {
+			return nil
+		}
+		chunk := p.buf
+		p.buf = nil
+		p.flushedAt = g.timeNow()
+		return streamFunc(ctx, index, chunk)
+	}
+	flushAll := func() error {
+		for index := range candidates {
+			if err := flush(index); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	stream := func(index int, text []byte) error {
+		if g.opts.streamingBufferSize <= 0 && g.opts.minChunkInterval <= 0 {
+			return streamFunc(ctx, index, text)
+		}
+		p := buffers[index]
+		if p == nil {
+			p = &pending{}
+			buffers[index] = p
+		}
+		p.buf = append(p.buf, text...)
+		if len(p.buf) < g.opts.streamingBufferSize || g.timeNow().Sub(p.flushedAt) < g.opts.minChunkInterval {
+			return nil
+		}
+		return flush(index)
+	}
+
 DoStream:
 	for {
 		resp, err := iter.Next()
 		if errors.Is(err, iterator.Done) {
+			if err := flushAll(); err != nil {
+				streamErr = fmt.Errorf("%w: %w", ErrStreamAborted, err)
+			}
 			break
 		}
+		if resp, ok := g.blockedResponse(err); ok {
+			return resp, nil
+		}
 		if err != nil {
-			log.Fatal(err)
+			apiErr = err
+			// Pass on the text received before the failure, as without a
+			// buffer; the API error takes precedence over any streaming error.
+			_ = flushAll()
+			return nil, classifyAPIError(err)
 		}
 
-		if len(resp.Candidates) != 1 {
+		if !multi && len(resp.Candidates) != 1 {
 			return nil, fmt.Errorf("expect single candidate in stream mode; got %v", len(resp.Candidates))
 		}
-		respCandidate := resp.Candidates[0]
-		candidate.Content.Parts = append(candidate.Content.Parts, respCandidate.Content.Parts...)
-		candidate.Content.Role = respCandidate.Content.Role
-		candidate.FinishReason = respCandidate.FinishReason
-		candidate.SafetyRatings = respCandidate.SafetyRatings
-		candidate.CitationMetadata = respCandidate.CitationMetadata
-		candidate.TokenCount += respCandidate.TokenCount
-
-		for _, part := range respCandidate.Content.Parts {
-			if text, ok := part.(genai.Text); ok {
-				if opts.StreamingFunc(ctx, []byte(text)) != nil {
-					break DoStream
+		for _, respCandidate := range resp.Candidates {
+			index := 0
+			if multi {
+				index = int(respCandidate.Index)
+			}
+			if index < 0 || index >= maxCandidates {
+				return nil, fmt.Errorf("unexpected candidate index %d in stream", index)
+			}
+			for len(candidates) <= index {
+				candidates = append(candidates, &genai.Candidate{Index: int32(len(candidates)), Content: &genai.Content{}})
+			}
+			if discard {
+				if respCandidate.Content != nil && len(respCandidate.Content.Parts) > 0 {
+					hasParts[index] = true
+				}
+				mergeStreamedCandidate(candidates[index], withoutContent(respCandidate))
+			} else {
+				mergeStreamedCandidate(candidates[index], respCandidate)
+			}
+			if respCandidate.Content == nil {
+				continue
+			}
+
+			for _, part := range respCandidate.Content.Parts {
+				if text, ok := part.(genai.Text); ok {
+					if timed && firstText.IsZero() {
+						firstText = g.timeNow()
+					}
+					if err := stream(index, []byte(text)); err != nil {
+						streamErr = fmt.Errorf("%w: %w", ErrStreamAborted, err)
+						drainStream(iter)
+						break DoStream
+					}
 				}
 			}
 		}
 	}
 
-	return convertCandidates([]*genai.Candidate{candidate})
+	var end time.Time
+	if timed {
+		end = g.timeNow()
+	}
+
+	resp, err := g.convertCandidates(candidates)
+	if err != nil {
+		return nil, err
+	}
+	if discard {
+		// The candidates only lack parts because they were discarded.
+		for index := range hasParts {
+			delete(resp.Choices[index].GenerationInfo, EMPTY_ANSWER)
+		}
+	}
+	if timed {
+		for _, choice := range resp.Choices {
+			if !firstText.IsZero() {
+				choice.GenerationInfo[TTFT_MS] = firstText.Sub(timedStream.start).Milliseconds()
+			}
+			choice.GenerationInfo[STREAM_DURATION_MS] = end.Sub(timedStream.start).Milliseconds()
+		}
+	}
+	return resp, streamErr
 }
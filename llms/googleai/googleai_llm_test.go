@@ -1,17 +1,64 @@
 package googleai
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/google/generative-ai-go/genai"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/tmc/langchaingo/llms"
 	"github.com/tmc/langchaingo/schema"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
 )
 
+// newFakeAPIClient returns a GoogleAI talking to the API at endpoint, e.g. an
+// httptest server.
+func newFakeAPIClient(t testing.TB, endpoint string, opts ...Option) *GoogleAI {
+	t.Helper()
+
+	g := &GoogleAI{opts: defaultOptions()}
+	for _, opt := range opts {
+		opt(&g.opts)
+	}
+
+	g.opts.apiKey = "key"
+	g.opts.endpoint = endpoint
+
+	var err error
+	g.client, err = newGenaiClient(context.Background(), &g.opts)
+	require.NoError(t, err)
+	return g
+}
+
+// fakeAPIResponse is a generateContent response with a single candidate.
+const fakeAPIResponse = `{"candidates": [{"content": {"role": "model", "parts": [{"text": "Hi"}]}, "finishReason": "STOP"}]}`
+
+// writeFakeAPIResponse writes fakeAPIResponse, as a stream of one response
+// for streaming requests.
+func writeFakeAPIResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if strings.Contains(r.URL.Path, ":streamGenerateContent") {
+		_, _ = w.Write([]byte("[" + fakeAPIResponse + "]"))
+		return
+	}
+	_, _ = w.Write([]byte(fakeAPIResponse))
+}
+
 func newClient(t *testing.T) *GoogleAI {
 	t.Helper()
 
@@ -147,3 +194,1779 @@ func TestEmbeddings(t *testing.T) {
 	assert.NotEmpty(t, res[0])
 	assert.NotEmpty(t, res[1])
 }
+
+func TestAPIKeyFromEnv(t *testing.T) { //nolint:paralleltest
+	t.Setenv(apiKeyEnvVarName, "")
+	t.Setenv(geminiAPIKeyEnvVarName, "")
+	t.Setenv(genaiAPIKeyEnvVarName, "")
+	assert.Equal(t, "", apiKeyFromEnv())
+
+	t.Setenv(genaiAPIKeyEnvVarName, "genai")
+	assert.Equal(t, "genai", apiKeyFromEnv())
+
+	t.Setenv(geminiAPIKeyEnvVarName, "gemini")
+	assert.Equal(t, "gemini", apiKeyFromEnv())
+
+	t.Setenv(apiKeyEnvVarName, "google")
+	assert.Equal(t, "google", apiKeyFromEnv())
+}
+
+func TestNewGoogleAIMissingAPIKey(t *testing.T) { //nolint:paralleltest
+	t.Setenv(apiKeyEnvVarName, "")
+	t.Setenv(geminiAPIKeyEnvVarName, "")
+	t.Setenv(genaiAPIKeyEnvVarName, "")
+
+	_, err := NewGoogleAI(context.Background())
+	require.ErrorIs(t, err, ErrMissingAPIKey)
+	for _, name := range apiKeyEnvVarNames {
+		assert.Contains(t, err.Error(), name)
+	}
+}
+
+func TestConvertCandidatesSafetyReportThreshold(t *testing.T) {
+	t.Parallel()
+
+	ratings := []*genai.SafetyRating{
+		{Category: genai.HarmCategoryHarassment, Probability: genai.HarmProbabilityNegligible},
+		{Category: genai.HarmCategoryHateSpeech, Probability: genai.HarmProbabilityMedium},
+		{Category: genai.HarmCategoryDangerousContent, Probability: genai.HarmProbabilityHigh},
+	}
+	candidates := []*genai.Candidate{
+		{
+			Content:       &genai.Content{Parts: []genai.Part{genai.Text("hello")}},
+			FinishReason:  genai.FinishReasonStop,
+			SafetyRatings: ratings,
+		},
+	}
+
+	g := &GoogleAI{opts: defaultOptions()}
+	rsp, err := g.convertCandidates(candidates)
+	require.NoError(t, err)
+	assert.Equal(t, ratings, rsp.Choices[0].GenerationInfo[SAFETY])
+	assert.NotContains(t, rsp.Choices[0].GenerationInfo, SAFETY_ALL)
+
+	WithSafetyReportThreshold(genai.HarmProbabilityMedium)(&g.opts)
+	rsp, err = g.convertCandidates(candidates)
+	require.NoError(t, err)
+	assert.Equal(t, ratings[1:], rsp.Choices[0].GenerationInfo[SAFETY])
+	assert.Equal(t, ratings, rsp.Choices[0].GenerationInfo[SAFETY_ALL])
+}
+
+func TestWithSafetyRatingsOnlyIfFlagged(t *testing.T) {
+	t.Parallel()
+
+	allClear := []*genai.SafetyRating{
+		{Category: genai.HarmCategoryHarassment, Probability: genai.HarmProbabilityNegligible},
+		{Category: genai.HarmCategoryHateSpeech, Probability: genai.HarmProbabilityLow},
+	}
+	flagged := []*genai.SafetyRating{
+		{Category: genai.HarmCategoryHarassment, Probability: genai.HarmProbabilityNegligible},
+		{Category: genai.HarmCategoryHateSpeech, Probability: genai.HarmProbabilityMedium},
+	}
+	blocked := []*genai.SafetyRating{
+		{Category: genai.HarmCategoryDangerousContent, Probability: genai.HarmProbabilityLow, Blocked: true},
+	}
+	candidates := []*genai.Candidate{
+		{Content: &genai.Content{Parts: []genai.Part{genai.Text("a")}}, FinishReason: genai.FinishReasonStop, SafetyRatings: allClear},
+		{Content: &genai.Content{Parts: []genai.Part{genai.Text("b")}}, FinishReason: genai.FinishReasonStop, SafetyRatings: flagged},
+		{Content: &genai.Content{Parts: []genai.Part{genai.Text("c")}}, FinishReason: genai.FinishReasonSafety, SafetyRatings: blocked},
+	}
+
+	// By default, safety ratings are always included.
+	g := &GoogleAI{opts: defaultOptions()}
+	rsp, err := g.convertCandidates(candidates)
+	require.NoError(t, err)
+	assert.Equal(t, allClear, rsp.Choices[0].GenerationInfo[SAFETY])
+
+	WithSafetyRatingsOnlyIfFlagged(genai.HarmProbabilityMedium)(&g.opts)
+	rsp, err = g.convertCandidates(candidates)
+	require.NoError(t, err)
+	assert.NotContains(t, rsp.Choices[0].GenerationInfo, SAFETY)
+	assert.Equal(t, flagged, rsp.Choices[1].GenerationInfo[SAFETY])
+	assert.Equal(t, blocked, rsp.Choices[2].GenerationInfo[SAFETY])
+
+	WithSafetyRatingsOnlyIfFlagged(genai.HarmProbabilityUnspecified)(&g.opts)
+	rsp, err = g.convertCandidates(candidates)
+	require.NoError(t, err)
+	assert.NotContains(t, rsp.Choices[0].GenerationInfo, SAFETY)
+	assert.NotContains(t, rsp.Choices[1].GenerationInfo, SAFETY)
+	assert.Equal(t, blocked, rsp.Choices[2].GenerationInfo[SAFETY])
+}
+
+func TestResponseToMessage(t *testing.T) {
+	t.Parallel()
+	g := &GoogleAI{opts: defaultOptions()}
+
+	msg := g.ResponseToMessage(&llms.ContentResponse{
+		Choices: []*llms.ContentChoice{{Content: "Spain is larger"}},
+	})
+	assert.Equal(t, llms.MessageContent{
+		Role:  schema.ChatMessageTypeAI,
+		Parts: []llms.ContentPart{llms.TextContent{Text: "Spain is larger"}},
+	}, msg)
+
+	msg = g.ResponseToMessage(&llms.ContentResponse{})
+	assert.Equal(t, schema.ChatMessageTypeAI, msg.Role)
+	assert.Empty(t, msg.Parts)
+}
+
+func TestGenerateFromMessagesLastMessageNotFromUser(t *testing.T) {
+	t.Parallel()
+	g := &GoogleAI{opts: defaultOptions()}
+
+	content := []llms.MessageContent{
+		{
+			Role:  schema.ChatMessageTypeHuman,
+			Parts: []llms.ContentPart{llms.TextContent{Text: "Name some countries"}},
+		},
+		{
+			Role:  schema.ChatMessageTypeAI,
+			Parts: []llms.ContentPart{llms.TextContent{Text: "Spain and Lesotho"}},
+		},
+	}
+
+	_, err := g.GenerateContent(context.Background(), content)
+	require.ErrorIs(t, err, ErrLastMessageNotFromUser)
+	assert.Contains(t, err.Error(), "trailing AI message")
+}
+
+func TestStreamContentText(t *testing.T) {
+	t.Parallel()
+	llm := newClient(t)
+
+	content := []llms.MessageContent{
+		{
+			Role:  schema.ChatMessageTypeHuman,
+			Parts: []llms.ContentPart{llms.TextContent{Text: "Tell me about pomeranians"}},
+		},
+	}
+
+	ch, err := llm.StreamContent(context.Background(), content)
+	require.NoError(t, err)
+
+	var sb strings.Builder
+	var final StreamChunk
+	for chunk := range ch {
+		sb.WriteString(chunk.Text)
+		final = chunk
+	}
+	require.NoError(t, final.Err)
+	require.NotNil(t, final.Response)
+	assert.Regexp(t, "dog|canid|canine", strings.ToLower(sb.String()))
+}
+
+func TestMaxHarmProbability(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, genai.HarmProbabilityUnspecified, MaxHarmProbability(nil))
+	assert.Equal(t, genai.HarmProbabilityUnspecified, MaxHarmProbability(&llms.ContentResponse{
+		Choices: []*llms.ContentChoice{{Content: "no ratings"}},
+	}))
+
+	rsp := &llms.ContentResponse{
+		Choices: []*llms.ContentChoice{
+			{GenerationInfo: map[string]any{SAFETY: []*genai.SafetyRating{
+				{Category: genai.HarmCategoryHarassment, Probability: genai.HarmProbabilityLow},
+			}}},
+			{GenerationInfo: map[string]any{SAFETY: []*genai.SafetyRating{
+				{Category: genai.HarmCategoryHarassment, Probability: genai.HarmProbabilityNegligible},
+				{Category: genai.HarmCategoryHateSpeech, Probability: genai.HarmProbabilityMedium},
+			}}},
+		},
+	}
+	assert.Equal(t, genai.HarmProbabilityMedium, MaxHarmProbability(rsp))
+}
+
+func TestEmbedDocumentsWithMetadata(t *testing.T) {
+	t.Parallel()
+	llm := newClient(t)
+
+	docs := []schema.Document{
+		{PageContent: "Parrots are birds", Metadata: map[string]any{"title": "Parrots"}},
+		{PageContent: "Pomeranians are dogs"},
+	}
+	res, err := llm.EmbedDocumentsWithMetadata(context.Background(), docs, "title")
+	require.NoError(t, err)
+
+	assert.Equal(t, len(docs), len(res))
+	assert.NotEmpty(t, res[0])
+	assert.NotEmpty(t, res[1])
+}
+
+func TestNewGoogleAIInvalidOptions(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		opts []Option
+	}{
+		{"empty model", []Option{WithDefaultModel("")}},
+		{"empty embedding model", []Option{WithDefaultEmbeddingModel("")}},
+		{"unknown threshold", []Option{WithSafetyReportThreshold(genai.HarmProbability(42))}},
+		{"unknown safety flag threshold", []Option{WithSafetyRatingsOnlyIfFlagged(genai.HarmProbability(-1))}},
+		{"negative max history messages", []Option{WithMaxHistoryMessages(-1)}},
+		{"auto chunk and truncate embeddings", []Option{WithAutoChunkEmbeddings(100), WithEmbeddingAutoTruncate(100)}},
+		{"negative connection pool size", []Option{WithConnectionPoolSize(-1)}},
+		{"negative min chunk interval", []Option{WithMinChunkInterval(-time.Second)}},
+		{"negative streaming buffer size", []Option{WithStreamingBufferSize(-1)}},
+		{"negative max parts per request", []Option{WithMaxPartsPerRequest(-1)}},
+		{"embedding fallback model", []Option{WithModelFallbackChain([]string{"embedding-001"})}},
+		{"fallback model not allowed", []Option{WithAllowedModels([]string{"gemini-pro"}), WithModelFallbackChain([]string{"gemini-1.0-pro"})}},
+		{"malformed proxy URL", []Option{WithProxy("http://proxy.example.com:port")}},
+		{"proxy URL without scheme", []Option{WithProxy("proxy.example.com:3128")}},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			opts := append([]Option{WithAPIKey("key")}, tt.opts...)
+			_, err := NewGoogleAI(context.Background(), opts...)
+			require.ErrorIs(t, err, ErrInvalidOptions)
+		})
+	}
+}
+
+// fakeIterator replays a fixed sequence of responses, implementing
+// responseIterator.
+type fakeIterator struct {
+	responses []*genai.GenerateContentResponse
+}
+
+func (it *fakeIterator) Next() (*genai.GenerateContentResponse, error) {
+	if len(it.responses) == 0 {
+		return nil, iterator.Done
+	}
+	resp := it.responses[0]
+	it.responses = it.responses[1:]
+	return resp, nil
+}
+
+func newFakeTextIterator(texts ...string) *fakeIterator {
+	chunks := make([][]genai.Part, 0, len(texts))
+	for _, text := range texts {
+		chunks = append(chunks, []genai.Part{genai.Text(text)})
+	}
+	return newFakePartsIterator(chunks...)
+}
+
+// newFakePartsIterator returns an iterator streaming a response chunk with
+// the parts of each of chunks. A nil chunk has no content, like the final
+// chunk of a stream that only reports the finish reason.
+func newFakePartsIterator(chunks ...[]genai.Part) *fakeIterator {
+	it := &fakeIterator{}
+	for _, parts := range chunks {
+		candidate := &genai.Candidate{FinishReason: genai.FinishReasonStop}
+		if parts != nil {
+			candidate.Content = &genai.Content{Role: RoleModel, Parts: parts}
+		}
+		it.responses = append(it.responses, &genai.GenerateContentResponse{
+			Candidates: []*genai.Candidate{candidate},
+		})
+	}
+	return it
+}
+
+func TestConvertAndStreamFromIteratorAborted(t *testing.T) {
+	t.Parallel()
+	g := &GoogleAI{opts: defaultOptions()}
+
+	errStop := errors.New("stop")
+	var chunks []string
+	opts := &llms.CallOptions{
+		StreamingFunc: func(ctx context.Context, chunk []byte) error {
+			chunks = append(chunks, string(chunk))
+			if len(chunks) == 2 {
+				return errStop
+			}
+			return nil
+		},
+	}
+
+	it := newFakeTextIterator("a", "b", "c", "d")
+	rsp, err := g.convertAndStreamFromIterator(context.Background(), it, opts)
+	require.ErrorIs(t, err, ErrStreamAborted)
+	require.ErrorIs(t, err, errStop)
+	assert.Equal(t, []string{"a", "b"}, chunks)
+	require.NotNil(t, rsp)
+	assert.Equal(t, "ab", rsp.Choices[0].Content)
+	// The rest of the stream is drained.
+	assert.Empty(t, it.responses)
+}
+
+func TestGenerateContentStreamAbortedCancelsStream(t *testing.T) {
+	t.Parallel()
+
+	canceled := make(chan bool, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte("[" + fakeAPIResponse + ",\n"))
+		w.(http.Flusher).Flush()
+		// A long stream, which the client has to hang up on.
+		select {
+		case <-r.Context().Done():
+			canceled <- true
+		case <-time.After(10 * time.Second):
+			canceled <- false
+		}
+	}))
+	defer srv.Close()
+
+	g := newFakeAPIClient(t, srv.URL)
+	errStop := errors.New("stop")
+	_, err := g.GenerateContent(context.Background(), []llms.MessageContent{{
+		Role:  schema.ChatMessageTypeHuman,
+		Parts: []llms.ContentPart{llms.TextContent{Text: "Hello"}},
+	}}, llms.WithStreamingFunc(func(context.Context, []byte) error {
+		return errStop
+	}))
+	require.ErrorIs(t, err, errStop)
+	assert.True(t, <-canceled, "stream wasn't canceled")
+}
+
+func TestConvertAndStreamFromIterator(t *testing.T) {
+	t.Parallel()
+	g := &GoogleAI{opts: defaultOptions()}
+
+	var sb strings.Builder
+	opts := &llms.CallOptions{
+		StreamingFunc: func(ctx context.Context, chunk []byte) error {
+			sb.Write(chunk)
+			return nil
+		},
+	}
+
+	rsp, err := g.convertAndStreamFromIterator(context.Background(), newFakeTextIterator("a", "b", "c"), opts)
+	require.NoError(t, err)
+	assert.Equal(t, "abc", sb.String())
+	assert.Equal(t, "abc", rsp.Choices[0].Content)
+}
+
+func TestConvertAndStreamFromIteratorThrottled(t *testing.T) {
+	t.Parallel()
+	g := &GoogleAI{opts: defaultOptions()}
+	WithMinChunkInterval(100 * time.Millisecond)(&g.opts)
+
+	// The clock advances by 40ms every time it is read.
+	now := time.Now()
+	g.now = func() time.Time {
+		now = now.Add(40 * time.Millisecond)
+		return now
+	}
+
+	var chunks []string
+	opts := &llms.CallOptions{
+		StreamingFunc: func(ctx context.Context, chunk []byte) error {
+			chunks = append(chunks, string(chunk))
+			return nil
+		},
+	}
+
+	rsp, err := g.convertAndStreamFromIterator(context.Background(), newFakeTextIterator("a", "b", "c", "d", "e", "f"), opts)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "bcd", "ef"}, chunks)
+	assert.Equal(t, "abcdef", rsp.Choices[0].Content)
+}
+
+func TestConvertAndStreamFromIteratorTiming(t *testing.T) {
+	t.Parallel()
+	g := &GoogleAI{opts: defaultOptions()}
+
+	// The clock advances by 40ms every time it is read.
+	start := time.Now()
+	now := start
+	g.now = func() time.Time {
+		now = now.Add(40 * time.Millisecond)
+		return now
+	}
+
+	var sb strings.Builder
+	opts := &llms.CallOptions{
+		StreamingFunc: func(ctx context.Context, chunk []byte) error {
+			sb.Write(chunk)
+			return nil
+		},
+	}
+
+	stream := &apiStream{responseIterator: newFakeTextIterator("a", "b", "c"), cancel: func() {}, start: start}
+	rsp, err := g.convertAndStreamFromIterator(context.Background(), stream, opts)
+	require.NoError(t, err)
+	assert.Equal(t, "abc", sb.String())
+	assert.Equal(t, "abc", rsp.Choices[0].Content)
+	assert.Equal(t, int64(40), rsp.Choices[0].GenerationInfo[TTFT_MS])
+	assert.Equal(t, int64(80), rsp.Choices[0].GenerationInfo[STREAM_DURATION_MS])
+
+	// Without text, there's no time to first token.
+	stream = &apiStream{responseIterator: newFakePartsIterator(nil), cancel: func() {}, start: now}
+	rsp, err = g.convertAndStreamFromIterator(context.Background(), stream, opts)
+	require.NoError(t, err)
+	assert.NotContains(t, rsp.Choices[0].GenerationInfo, TTFT_MS)
+	assert.Equal(t, int64(40), rsp.Choices[0].GenerationInfo[STREAM_DURATION_MS])
+
+	// Other iterators aren't timed.
+	rsp, err = g.convertAndStreamFromIterator(context.Background(), newFakeTextIterator("a"), opts)
+	require.NoError(t, err)
+	assert.NotContains(t, rsp.Choices[0].GenerationInfo, TTFT_MS)
+	assert.NotContains(t, rsp.Choices[0].GenerationInfo, STREAM_DURATION_MS)
+}
+
+func TestWithDiscardStreamAggregate(t *testing.T) {
+	t.Parallel()
+	g := &GoogleAI{opts: defaultOptions()}
+
+	var sb strings.Builder
+	opts := &llms.CallOptions{
+		StreamingFunc: func(ctx context.Context, chunk []byte) error {
+			sb.Write(chunk)
+			return nil
+		},
+	}
+	WithDiscardStreamAggregate()(opts)
+
+	image := genai.Blob{MIMEType: "image/png", Data: []byte("png data")}
+	it := newFakePartsIterator([]genai.Part{genai.Text("a")}, []genai.Part{image, genai.Text("b")}, nil)
+	rsp, err := g.convertAndStreamFromIterator(context.Background(), it, opts)
+	require.NoError(t, err)
+	assert.Equal(t, "ab", sb.String())
+	require.Len(t, rsp.Choices, 1)
+	assert.Empty(t, rsp.Choices[0].Content)
+	assert.Equal(t, genai.FinishReasonStop.String(), rsp.Choices[0].StopReason)
+	assert.NotContains(t, rsp.Choices[0].GenerationInfo, INLINE_DATA)
+	assert.False(t, IsEmptyAnswer(rsp.Choices[0]))
+
+	// An empty answer is still reported as such.
+	rsp, err = g.convertAndStreamFromIterator(context.Background(), newFakePartsIterator(nil), opts)
+	require.NoError(t, err)
+	assert.True(t, IsEmptyAnswer(rsp.Choices[0]))
+}
+
+func TestConvertAndStreamFromIteratorMultipleCandidates(t *testing.T) {
+	t.Parallel()
+	g := &GoogleAI{opts: defaultOptions()}
+
+	candidate := func(index int32, text string) *genai.Candidate {
+		return &genai.Candidate{
+			Index:        index,
+			FinishReason: genai.FinishReasonStop,
+			Content:      &genai.Content{Role: RoleModel, Parts: []genai.Part{genai.Text(text)}},
+		}
+	}
+	first := &genai.GenerateContentResponse{Candidates: []*genai.Candidate{candidate(0, "a"), candidate(1, "x")}}
+	it := &fakeIterator{responses: []*genai.GenerateContentResponse{
+		first,
+		{Candidates: []*genai.Candidate{candidate(1, "y")}},
+		{Candidates: []*genai.Candidate{candidate(0, "b"), candidate(1, "z")}},
+	}}
+
+	streamed := map[int][]string{}
+	opts := &llms.CallOptions{}
+	WithStreamingFuncN(func(ctx context.Context, index int, chunk []byte) error {
+		streamed[index] = append(streamed[index], string(chunk))
+		return nil
+	})(opts)
+
+	rsp, err := g.convertAndStreamFromIterator(context.Background(), it, opts)
+	require.NoError(t, err)
+	assert.Equal(t, map[int][]string{0: {"a", "b"}, 1: {"x", "y", "z"}}, streamed)
+	require.Len(t, rsp.Choices, 2)
+	assert.Equal(t, "ab", rsp.Choices[0].Content)
+	assert.Equal(t, "xyz", rsp.Choices[1].Content)
+
+	// Without WithStreamingFuncN, multiple candidates are an error.
+	it = &fakeIterator{responses: []*genai.GenerateContentResponse{first}}
+	opts = &llms.CallOptions{StreamingFunc: func(context.Context, []byte) error { return nil }}
+	_, err = g.convertAndStreamFromIterator(context.Background(), it, opts)
+	require.ErrorContains(t, err, "expect single candidate")
+}
+
+func TestConvertAndStreamFromIteratorPartOrder(t *testing.T) {
+	t.Parallel()
+	g := &GoogleAI{opts: defaultOptions()}
+
+	image := genai.Blob{MIMEType: "image/png", Data: []byte("png data")}
+	chunks := [][]genai.Part{
+		{genai.Text("a")},
+		{image, genai.Text("b")},
+		{genai.Text("c")},
+		nil,
+	}
+
+	var streamed strings.Builder
+	opts := &llms.CallOptions{
+		StreamingFunc: func(ctx context.Context, chunk []byte) error {
+			streamed.Write(chunk)
+			return nil
+		},
+	}
+	rsp, err := g.convertAndStreamFromIterator(context.Background(), newFakePartsIterator(chunks...), opts)
+	require.NoError(t, err)
+	assert.Equal(t, "abc", streamed.String())
+	assert.Equal(t, "abc", rsp.Choices[0].Content)
+	assert.Equal(t, []InlineData{{MIMEType: "image/png", Data: "cG5nIGRhdGE="}}, rsp.Choices[0].GenerationInfo[INLINE_DATA])
+
+	candidate := &genai.Candidate{Content: &genai.Content{}}
+	it := newFakePartsIterator(chunks...)
+	for _, resp := range it.responses {
+		mergeStreamedCandidate(candidate, resp.Candidates[0])
+	}
+	assert.Equal(t, []genai.Part{genai.Text("a"), image, genai.Text("b"), genai.Text("c")}, candidate.Content.Parts)
+	assert.Equal(t, RoleModel, candidate.Content.Role)
+	assert.Equal(t, genai.FinishReasonStop, candidate.FinishReason)
+}
+
+func TestConvertAndStreamFromIteratorBuffered(t *testing.T) {
+	t.Parallel()
+	g := &GoogleAI{opts: defaultOptions()}
+	WithStreamingBufferSize(3)(&g.opts)
+
+	var chunks []string
+	opts := &llms.CallOptions{
+		StreamingFunc: func(ctx context.Context, chunk []byte) error {
+			chunks = append(chunks, string(chunk))
+			return nil
+		},
+	}
+
+	rsp, err := g.convertAndStreamFromIterator(context.Background(), newFakeTextIterator("a", "b", "cd", "e", "f"), opts)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"abcd", "ef"}, chunks)
+	assert.Equal(t, "abcdef", rsp.Choices[0].Content)
+}
+
+func TestIsEmptyAnswer(t *testing.T) {
+	t.Parallel()
+	g := &GoogleAI{opts: defaultOptions()}
+
+	rsp, err := g.convertCandidates([]*genai.Candidate{
+		{Content: &genai.Content{Role: RoleModel}, FinishReason: genai.FinishReasonStop},
+		{FinishReason: genai.FinishReasonStop},
+		{Content: &genai.Content{Parts: []genai.Part{genai.Text("Hi")}}, FinishReason: genai.FinishReasonStop},
+		{Content: &genai.Content{}, FinishReason: genai.FinishReasonMaxTokens},
+	})
+	require.NoError(t, err)
+	assert.True(t, IsEmptyAnswer(rsp.Choices[0]))
+	assert.True(t, IsEmptyAnswer(rsp.Choices[1]))
+	assert.Equal(t, genai.FinishReasonStop.String(), rsp.Choices[1].StopReason)
+	assert.False(t, IsEmptyAnswer(rsp.Choices[2]))
+	assert.False(t, IsEmptyAnswer(rsp.Choices[3]))
+	assert.False(t, IsEmptyAnswer(nil))
+}
+
+func TestIsTruncated(t *testing.T) {
+	t.Parallel()
+	g := &GoogleAI{opts: defaultOptions()}
+
+	rsp, err := g.convertCandidates([]*genai.Candidate{
+		{Content: &genai.Content{}, FinishReason: genai.FinishReasonMaxTokens},
+		{Content: &genai.Content{}, FinishReason: genai.FinishReasonStop},
+	})
+	require.NoError(t, err)
+	assert.True(t, IsTruncated(rsp.Choices[0]))
+	assert.False(t, IsTruncated(rsp.Choices[1]))
+	assert.False(t, IsTruncated(nil))
+}
+
+func TestEmbeddingsWithModel(t *testing.T) {
+	t.Parallel()
+	llm := newClient(t)
+
+	texts := []string{"foo", "parrot"}
+	res, err := llm.CreateEmbeddingWithOptions(context.Background(), texts, WithEmbeddingModel("embedding-001"))
+	require.NoError(t, err)
+
+	assert.Equal(t, len(texts), len(res))
+	assert.NotEmpty(t, res[0])
+	assert.NotEmpty(t, res[1])
+}
+
+func TestGenerationConfigMerge(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		config     *genai.GenerationConfig
+		callOpts   []llms.CallOption
+		wantConfig genai.GenerationConfig
+	}{
+		{
+			name: "defaults only",
+			wantConfig: genai.GenerationConfig{
+				MaxOutputTokens: genai.Ptr[int32](256),
+				Temperature:     genai.Ptr[float32](0.5),
+			},
+		},
+		{
+			name:     "call options only",
+			callOpts: []llms.CallOption{llms.WithMaxTokens(10), llms.WithTemperature(0), llms.WithTopK(3), llms.WithStopWords([]string{"x"})},
+			wantConfig: genai.GenerationConfig{
+				MaxOutputTokens: genai.Ptr[int32](10),
+				Temperature:     genai.Ptr[float32](0),
+				TopK:            genai.Ptr[int32](3),
+				StopSequences:   []string{"x"},
+			},
+		},
+		{
+			name: "config only",
+			config: &genai.GenerationConfig{
+				MaxOutputTokens: genai.Ptr[int32](100),
+				Temperature:     genai.Ptr[float32](0.9),
+				TopP:            genai.Ptr[float32](0.8),
+			},
+			wantConfig: genai.GenerationConfig{
+				MaxOutputTokens: genai.Ptr[int32](100),
+				Temperature:     genai.Ptr[float32](0.9),
+				TopP:            genai.Ptr[float32](0.8),
+			},
+		},
+		{
+			name: "call options override config",
+			config: &genai.GenerationConfig{
+				MaxOutputTokens: genai.Ptr[int32](100),
+				Temperature:     genai.Ptr[float32](0.9),
+				TopP:            genai.Ptr[float32](0.8),
+			},
+			callOpts: []llms.CallOption{llms.WithTemperature(0.1), llms.WithN(2)},
+			wantConfig: genai.GenerationConfig{
+				CandidateCount:  genai.Ptr[int32](2),
+				MaxOutputTokens: genai.Ptr[int32](100),
+				Temperature:     genai.Ptr[float32](0.1),
+				TopP:            genai.Ptr[float32](0.8),
+			},
+		},
+		{
+			name:   "config partially set",
+			config: &genai.GenerationConfig{TopK: genai.Ptr[int32](5)},
+			wantConfig: genai.GenerationConfig{
+				MaxOutputTokens: genai.Ptr[int32](256),
+				Temperature:     genai.Ptr[float32](0.5),
+				TopK:            genai.Ptr[int32](5),
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			g := &GoogleAI{opts: defaultOptions()}
+			if tt.config != nil {
+				WithGenerationConfig(*tt.config)(&g.opts)
+			}
+			opts := g.callOptions(tt.callOpts...)
+			assert.Equal(t, tt.wantConfig, g.generationConfig(&opts))
+		})
+	}
+}
+
+func TestCitationSegments(t *testing.T) {
+	t.Parallel()
+
+	assert.Nil(t, citationSegments("", nil))
+	assert.Equal(t, []CitationSegment{{Text: "uncited"}}, citationSegments("uncited", nil))
+
+	metadata := &genai.CitationMetadata{
+		CitationSources: []*genai.CitationSource{
+			{StartIndex: genai.Ptr[int32](4), EndIndex: genai.Ptr[int32](12)},
+			{StartIndex: genai.Ptr[int32](8), EndIndex: genai.Ptr[int32](100)},
+		},
+	}
+	assert.Equal(t, []CitationSegment{
+		{Text: "The "},
+		{Text: "quic", SourceIndices: []int{0}},
+		{Text: "k br", SourceIndices: []int{0, 1}},
+		{Text: "own fox", SourceIndices: []int{1}},
+	}, citationSegments("The quick brown fox", metadata))
+}
+
+func TestConvertCandidatesCitationSegments(t *testing.T) {
+	t.Parallel()
+	g := &GoogleAI{opts: defaultOptions()}
+	WithCitationSegments()(&g.opts)
+
+	rsp, err := g.convertCandidates([]*genai.Candidate{{
+		Content: &genai.Content{Parts: []genai.Part{genai.Text("hello world")}},
+		CitationMetadata: &genai.CitationMetadata{
+			CitationSources: []*genai.CitationSource{{StartIndex: genai.Ptr[int32](6), EndIndex: genai.Ptr[int32](11)}},
+		},
+	}})
+	require.NoError(t, err)
+	assert.Equal(t, []CitationSegment{
+		{Text: "hello "},
+		{Text: "world", SourceIndices: []int{0}},
+	}, rsp.Choices[0].GenerationInfo[SEGMENTS])
+}
+
+func TestConvertAndStreamFromIteratorCitations(t *testing.T) {
+	t.Parallel()
+	g := &GoogleAI{opts: defaultOptions()}
+	WithCitationSegments()(&g.opts)
+
+	cite := func(start, end int32) *genai.CitationMetadata {
+		return &genai.CitationMetadata{CitationSources: []*genai.CitationSource{{StartIndex: &start, EndIndex: &end}}}
+	}
+	chunk := func(text string, citations *genai.CitationMetadata) *genai.GenerateContentResponse {
+		candidate := &genai.Candidate{FinishReason: genai.FinishReasonStop, CitationMetadata: citations}
+		if text != "" {
+			candidate.Content = &genai.Content{Role: RoleModel, Parts: []genai.Part{genai.Text(text)}}
+		}
+		return &genai.GenerateContentResponse{Candidates: []*genai.Candidate{candidate}}
+	}
+	// Citations of chunks with text index that text; those of a chunk
+	// without text index the whole text.
+	second := cite(0, 5)
+	it := &fakeIterator{responses: []*genai.GenerateContentResponse{
+		chunk("hello ", cite(0, 5)),
+		chunk("world", second),
+		chunk("", cite(2, 4)),
+	}}
+	opts := &llms.CallOptions{StreamingFunc: func(context.Context, []byte) error { return nil }}
+
+	rsp, err := g.convertAndStreamFromIterator(context.Background(), it, opts)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", rsp.Choices[0].Content)
+	assert.Equal(t, []CitationSegment{
+		{Text: "he", SourceIndices: []int{0}},
+		{Text: "ll", SourceIndices: []int{0, 2}},
+		{Text: "o", SourceIndices: []int{0}},
+		{Text: " "},
+		{Text: "world", SourceIndices: []int{1}},
+	}, rsp.Choices[0].GenerationInfo[SEGMENTS])
+	assert.Equal(t, int32(0), *second.CitationSources[0].StartIndex, "chunk was modified")
+}
+
+// countingTransport counts the requests made through it.
+type countingTransport struct {
+	requests int
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.requests++
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestConvertPartsImageURLWithHTTPClient(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		_, _ = w.Write([]byte("png data"))
+	}))
+	defer srv.Close()
+
+	transport := &countingTransport{}
+	g := &GoogleAI{opts: defaultOptions()}
+	WithHTTPClient(&http.Client{Transport: transport})(&g.opts)
+
+	parts, err := g.convertParts(context.Background(), []llms.ContentPart{llms.ImageURLContent{URL: srv.URL}})
+	require.NoError(t, err)
+	assert.Equal(t, []genai.Part{&genai.Blob{MIMEType: "image/png", Data: []byte("png data")}}, parts)
+	assert.Equal(t, 1, transport.requests)
+}
+
+func TestWithProxy(t *testing.T) {
+	t.Parallel()
+
+	var proxied []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = append(proxied, r.URL.String())
+		w.Header().Set("Content-Type", "image/png")
+		_, _ = w.Write([]byte("png data"))
+	}))
+	defer proxy.Close()
+
+	g, err := NewGoogleAI(context.Background(), WithAPIKey("key"), WithProxy(proxy.URL))
+	require.NoError(t, err)
+
+	parts, err := g.convertParts(context.Background(), []llms.ContentPart{llms.ImageURLContent{URL: "http://images.example/cat.png"}})
+	require.NoError(t, err)
+	assert.Equal(t, []genai.Part{&genai.Blob{MIMEType: "image/png", Data: []byte("png data")}}, parts)
+	assert.Equal(t, []string{"http://images.example/cat.png"}, proxied)
+}
+
+func TestAPITransportPoolSize(t *testing.T) {
+	t.Parallel()
+
+	opts := defaultOptions()
+	assert.Equal(t, defaultMaxIdleConnsPerHost, opts.apiTransport().MaxIdleConnsPerHost)
+
+	WithConnectionPoolSize(8)(&opts)
+	assert.Equal(t, 8, opts.apiTransport().MaxIdleConnsPerHost)
+}
+
+// This test replaces http.DefaultTransport, so it must not run in parallel.
+func TestAPITransportReplacedDefaultTransport(t *testing.T) { //nolint:paralleltest
+	defaultTransport := http.DefaultTransport
+	http.DefaultTransport = &countingTransport{}
+	t.Cleanup(func() { http.DefaultTransport = defaultTransport })
+
+	opts := defaultOptions()
+	var transport *http.Transport
+	require.NotPanics(t, func() { transport = opts.apiTransport() })
+	assert.Equal(t, defaultMaxIdleConnsPerHost, transport.MaxIdleConnsPerHost)
+	assert.NotNil(t, transport.DialContext)
+}
+
+func BenchmarkConnectionPoolSize(b *testing.B) {
+	srv := httptest.NewServer(http.HandlerFunc(writeFakeAPIResponse))
+	defer srv.Close()
+
+	messages := []llms.MessageContent{{
+		Role:  schema.ChatMessageTypeHuman,
+		Parts: []llms.ContentPart{llms.TextContent{Text: "Hello"}},
+	}}
+	for _, size := range []int{1, 4, 8} {
+		size := size
+		b.Run(fmt.Sprintf("size=%d", size), func(b *testing.B) {
+			g := newFakeAPIClient(b, srv.URL, WithConnectionPoolSize(size))
+
+			b.SetParallelism(8)
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if _, err := g.GenerateContent(context.Background(), messages); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
+
+func TestConvertPartsImageURLNotAnImage(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = w.Write([]byte("<html>Sign in</html>"))
+	}))
+	defer srv.Close()
+
+	g := &GoogleAI{opts: defaultOptions()}
+	_, err := g.convertParts(context.Background(), []llms.ContentPart{llms.ImageURLContent{URL: srv.URL}})
+	require.ErrorIs(t, err, ErrInvalidMimeType)
+	assert.Contains(t, err.Error(), "text/html")
+}
+
+func TestDownloadImageDataContentTypes(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", r.URL.Query().Get("type"))
+		_, _ = w.Write([]byte("image data"))
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		contentType string
+		want        string
+	}{
+		{"image/png", "image/png"},
+		{"image/jpeg", "image/jpeg"},
+		{"image/jpeg; charset=binary", "image/jpeg"},
+		{"Image/JPEG", "image/jpeg"},
+		{"image/x-png", "image/png"},
+		{"image/jpg", "image/jpeg"},
+		{"image/pjpeg", "image/jpeg"},
+		{"image/webp", "image/webp"},
+		{"image/heic", "image/heic"},
+		{"image/svg+xml", ""},
+		{"image/gif", ""},
+		{"application/octet-stream", ""},
+		{"image/", ""},
+	}
+	for _, tt := range tests {
+		blob, err := downloadImageData(context.Background(), http.DefaultClient, srv.URL+"?type="+url.QueryEscape(tt.contentType))
+		if tt.want == "" {
+			require.ErrorIs(t, err, ErrInvalidMimeType, tt.contentType)
+			continue
+		}
+		require.NoError(t, err, tt.contentType)
+		assert.Equal(t, tt.want, blob.MIMEType, tt.contentType)
+	}
+
+	_, err := downloadImageData(context.Background(), http.DefaultClient, srv.URL+"?type=image/gif")
+	assert.ErrorContains(t, err, "image format gif")
+}
+
+func TestGenerateRaw(t *testing.T) {
+	t.Parallel()
+	llm := newClient(t)
+
+	rsp, err := llm.GenerateRaw(context.Background(), "gemini-pro", []genai.Part{
+		genai.Text("I'm a pomeranian"),
+		genai.Text("What kind of mammal am I?"),
+	})
+	require.NoError(t, err)
+
+	require.NotEmpty(t, rsp.Candidates)
+	require.NotEmpty(t, rsp.Candidates[0].Content.Parts)
+	text, ok := rsp.Candidates[0].Content.Parts[0].(genai.Text)
+	require.True(t, ok)
+	assert.Regexp(t, "dog|canid|canine", strings.ToLower(string(text)))
+}
+
+func TestConvertPartsBinaryContentMIMEType(t *testing.T) {
+	t.Parallel()
+	g := &GoogleAI{opts: defaultOptions()}
+
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	text := []byte("Plain text, detected with a charset")
+	parts, err := g.convertParts(context.Background(), []llms.ContentPart{
+		llms.BinaryContent{Data: png},
+		llms.BinaryContent{MIMEType: "image/jpeg", Data: png},
+		llms.BinaryContent{Data: text},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []genai.Part{
+		genai.Blob{MIMEType: "image/png", Data: png},
+		genai.Blob{MIMEType: "image/jpeg", Data: png},
+		genai.Blob{MIMEType: "text/plain", Data: text},
+	}, parts)
+
+	_, err = g.convertParts(context.Background(), []llms.ContentPart{llms.BinaryContent{Data: []byte{0x00, 0x01, 0x02}}})
+	require.ErrorIs(t, err, ErrInvalidMimeType)
+}
+
+func TestMaxConcurrentRequests(t *testing.T) {
+	t.Parallel()
+	g := &GoogleAI{sem: make(chan struct{}, 1)}
+
+	require.NoError(t, g.acquire(context.Background()))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.ErrorIs(t, g.acquire(ctx), context.Canceled)
+
+	_, err := g.GenerateContent(ctx, []llms.MessageContent{
+		{Role: schema.ChatMessageTypeHuman, Parts: []llms.ContentPart{llms.TextContent{Text: "hello"}}},
+	})
+	require.ErrorIs(t, err, context.Canceled)
+
+	g.release()
+	require.NoError(t, g.acquire(context.Background()))
+	g.release()
+}
+
+func TestSafetySettingsPrecedence(t *testing.T) {
+	t.Parallel()
+
+	thresholds := func(model *genai.GenerativeModel) []genai.HarmBlockThreshold {
+		var got []genai.HarmBlockThreshold
+		for _, setting := range model.SafetySettings {
+			got = append(got, setting.Threshold)
+		}
+		return got
+	}
+
+	g := &GoogleAI{opts: defaultOptions()}
+	opts := g.callOptions()
+	assert.Nil(t, g.generativeModel("gemini-pro", &opts).SafetySettings)
+
+	opts = g.callOptions(WithCallHarmThreshold(genai.HarmBlockNone))
+	assert.Equal(t, []genai.HarmBlockThreshold{
+		genai.HarmBlockNone, genai.HarmBlockNone, genai.HarmBlockNone, genai.HarmBlockNone,
+	}, thresholds(g.generativeModel("gemini-pro", &opts)))
+
+	WithHarmThreshold(genai.HarmBlockLowAndAbove)(&g.opts)
+	opts = g.callOptions()
+	assert.Equal(t, []genai.HarmBlockThreshold{
+		genai.HarmBlockLowAndAbove, genai.HarmBlockLowAndAbove, genai.HarmBlockLowAndAbove, genai.HarmBlockLowAndAbove,
+	}, thresholds(g.generativeModel("gemini-pro", &opts)))
+
+	opts = g.callOptions(WithCallHarmThreshold(genai.HarmBlockOnlyHigh))
+	assert.Equal(t, []genai.HarmBlockThreshold{
+		genai.HarmBlockOnlyHigh, genai.HarmBlockOnlyHigh, genai.HarmBlockOnlyHigh, genai.HarmBlockOnlyHigh,
+	}, thresholds(g.generativeModel("gemini-pro", &opts)))
+}
+
+func TestConvertCandidatesInlineData(t *testing.T) {
+	t.Parallel()
+	g := &GoogleAI{opts: defaultOptions()}
+
+	rsp, err := g.convertCandidates([]*genai.Candidate{{
+		Content: &genai.Content{Parts: []genai.Part{
+			genai.Text("here is a picture"),
+			genai.Blob{MIMEType: "image/png", Data: []byte("png data")},
+		}},
+	}})
+	require.NoError(t, err)
+	assert.Equal(t, "here is a picture", rsp.Choices[0].Content)
+	assert.Equal(t, []InlineData{{MIMEType: "image/png", Data: "cG5nIGRhdGE="}}, rsp.Choices[0].GenerationInfo[INLINE_DATA])
+}
+
+func TestStreamToWriter(t *testing.T) {
+	t.Parallel()
+	llm := newClient(t)
+
+	content := []llms.MessageContent{
+		{
+			Role:  schema.ChatMessageTypeHuman,
+			Parts: []llms.ContentPart{llms.TextContent{Text: "Tell me about pomeranians"}},
+		},
+	}
+
+	w := httptest.NewRecorder()
+	rsp, err := llm.StreamToWriter(context.Background(), content, w)
+	require.NoError(t, err)
+
+	assert.True(t, w.Flushed)
+	assert.Equal(t, rsp.Choices[0].Content, w.Body.String())
+}
+
+func TestGenerateContentNoMessages(t *testing.T) {
+	t.Parallel()
+	g := &GoogleAI{opts: defaultOptions()}
+
+	_, err := g.GenerateContent(context.Background(), nil)
+	require.ErrorIs(t, err, ErrNoMessages)
+
+	_, err = g.StreamContent(context.Background(), []llms.MessageContent{})
+	require.ErrorIs(t, err, ErrNoMessages)
+}
+
+func TestGenerateContentEmptyMessage(t *testing.T) {
+	t.Parallel()
+	g := &GoogleAI{opts: defaultOptions()}
+
+	content := []llms.MessageContent{
+		{
+			Role:  schema.ChatMessageTypeHuman,
+			Parts: []llms.ContentPart{llms.TextContent{Text: "Name some countries"}},
+		},
+		{
+			Role: schema.ChatMessageTypeAI,
+		},
+		{
+			Role:  schema.ChatMessageTypeHuman,
+			Parts: []llms.ContentPart{llms.TextContent{Text: "Which if these is larger?"}},
+		},
+	}
+
+	_, err := g.GenerateContent(context.Background(), content)
+	require.ErrorIs(t, err, ErrEmptyMessage)
+	assert.Contains(t, err.Error(), "message 1")
+}
+
+func TestNewGoogleAITokenSource(t *testing.T) {
+	t.Parallel()
+	ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "token"})
+
+	_, err := NewGoogleAI(context.Background(), WithAPIKey("key"), WithTokenSource(ts))
+	require.ErrorIs(t, err, ErrInvalidOptions)
+
+	llm, err := NewGoogleAI(context.Background(), WithTokenSource(ts))
+	require.NoError(t, err)
+	assert.Empty(t, llm.opts.apiKey)
+}
+
+func TestConvertResponseEmptyResponsePolicy(t *testing.T) {
+	t.Parallel()
+
+	resp := &genai.GenerateContentResponse{
+		PromptFeedback: &genai.PromptFeedback{BlockReason: genai.BlockReasonOther},
+	}
+
+	g := &GoogleAI{opts: defaultOptions()}
+	_, err := g.convertResponse(resp)
+	require.ErrorIs(t, err, ErrNoContentInResponse)
+
+	WithEmptyResponsePolicy(EmptyResponseReturnEmpty)(&g.opts)
+	rsp, err := g.convertResponse(resp)
+	require.NoError(t, err)
+	require.Len(t, rsp.Choices, 1)
+	assert.Empty(t, rsp.Choices[0].Content)
+	assert.Equal(t, genai.BlockReasonOther.String(), rsp.Choices[0].GenerationInfo[BLOCK_REASON])
+
+	rsp, err = g.convertResponse(&genai.GenerateContentResponse{})
+	require.NoError(t, err)
+	require.Len(t, rsp.Choices, 1)
+	assert.NotContains(t, rsp.Choices[0].GenerationInfo, BLOCK_REASON)
+}
+
+func TestModelParameters(t *testing.T) {
+	t.Parallel()
+	g := &GoogleAI{opts: defaultOptions()}
+	WithGenerationConfig(genai.GenerationConfig{TopK: genai.Ptr[int32](5)})(&g.opts)
+	WithHarmThreshold(genai.HarmBlockLowAndAbove)(&g.opts)
+
+	settings := []*genai.SafetySetting{{Category: genai.HarmCategoryHarassment, Threshold: genai.HarmBlockNone}}
+	opts := g.callOptions(
+		WithCallHarmThreshold(genai.HarmBlockOnlyHigh),
+		WithModelParameters(ModelParameters{
+			GenerationConfig: &genai.GenerationConfig{Temperature: genai.Ptr[float32](0.9)},
+			SafetySettings:   settings,
+		}),
+		llms.WithMaxTokens(10),
+	)
+	model := g.generativeModel("gemini-pro", &opts)
+
+	assert.Equal(t, genai.GenerationConfig{
+		MaxOutputTokens: genai.Ptr[int32](10),
+		Temperature:     genai.Ptr[float32](0.9),
+	}, model.GenerationConfig)
+	assert.Equal(t, settings, model.SafetySettings)
+}
+
+func TestWithDefaultCallOptions(t *testing.T) {
+	t.Parallel()
+	g := &GoogleAI{opts: defaultOptions()}
+	WithDefaultCallOptions(
+		llms.WithTemperature(0.2),
+		llms.WithMaxTokens(200),
+		llms.WithStopWords([]string{"STOP"}),
+	)(&g.opts)
+
+	opts := g.callOptions()
+	assert.InDelta(t, 0.2, opts.Temperature, 1e-6)
+	assert.Equal(t, 200, opts.MaxTokens)
+	assert.Equal(t, []string{"STOP"}, opts.StopWords)
+
+	opts = g.callOptions(llms.WithMaxTokens(300))
+	assert.InDelta(t, 0.2, opts.Temperature, 1e-6)
+	assert.Equal(t, 300, opts.MaxTokens)
+	assert.Equal(t, []string{"STOP"}, opts.StopWords)
+}
+
+func BenchmarkGenerativeModel(b *testing.B) {
+	g := &GoogleAI{opts: defaultOptions()}
+	WithHarmThreshold(genai.HarmBlockOnlyHigh)(&g.opts)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		opts := g.callOptions(llms.WithMaxTokens(100), llms.WithTopK(3))
+		_ = g.generativeModel("gemini-pro", &opts)
+	}
+}
+
+func TestWithOmitResponseMetadata(t *testing.T) {
+	t.Parallel()
+	g := &GoogleAI{opts: defaultOptions()}
+	WithOmitResponseMetadata()(&g.opts)
+
+	rsp, err := g.convertCandidates(benchmarkCandidates())
+	require.NoError(t, err)
+	assert.Equal(t, "Hello", rsp.Choices[0].Content)
+	assert.Empty(t, rsp.Choices[0].GenerationInfo)
+}
+
+// benchmarkCandidates returns a typical candidate with safety ratings and
+// citation metadata.
+func benchmarkCandidates() []*genai.Candidate {
+	ratings := make([]*genai.SafetyRating, 0, len(harmCategories))
+	for _, category := range harmCategories {
+		ratings = append(ratings, &genai.SafetyRating{Category: category, Probability: genai.HarmProbabilityNegligible})
+	}
+	return []*genai.Candidate{{
+		Content:          &genai.Content{Role: RoleModel, Parts: []genai.Part{genai.Text("Hello")}},
+		FinishReason:     genai.FinishReasonStop,
+		SafetyRatings:    ratings,
+		CitationMetadata: &genai.CitationMetadata{CitationSources: []*genai.CitationSource{{StartIndex: genai.Ptr[int32](0)}}},
+	}}
+}
+
+func BenchmarkConvertCandidates(b *testing.B) {
+	for _, omit := range []bool{false, true} {
+		omit := omit
+		b.Run(fmt.Sprintf("omit=%v", omit), func(b *testing.B) {
+			g := &GoogleAI{opts: defaultOptions()}
+			WithSafetyReportThreshold(genai.HarmProbabilityLow)(&g.opts)
+			if omit {
+				WithOmitResponseMetadata()(&g.opts)
+			}
+			candidates := benchmarkCandidates()
+
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				_, _ = g.convertCandidates(candidates)
+			}
+		})
+	}
+}
+
+func TestConvertContentRoles(t *testing.T) {
+	t.Parallel()
+	g := &GoogleAI{opts: defaultOptions()}
+
+	tests := []struct {
+		role    schema.ChatMessageType
+		want    string
+		wantErr bool
+	}{
+		{schema.ChatMessageTypeHuman, RoleUser, false},
+		{schema.ChatMessageTypeGeneric, RoleUser, false},
+		{schema.ChatMessageTypeAI, RoleModel, false},
+		{schema.ChatMessageTypeSystem, "", true},
+		{schema.ChatMessageTypeFunction, "", true},
+		{schema.ChatMessageType("tool"), "", true},
+	}
+	for _, tt := range tests {
+		content, err := g.convertContent(context.Background(), llms.MessageContent{
+			Role:  tt.role,
+			Parts: []llms.ContentPart{llms.TextContent{Text: "Hello"}},
+		})
+		if tt.wantErr {
+			require.Error(t, err, tt.role)
+			continue
+		}
+		require.NoError(t, err, tt.role)
+		assert.Equal(t, tt.want, content.Role, tt.role)
+		assert.Equal(t, []genai.Part{genai.Text("Hello")}, content.Parts, tt.role)
+	}
+
+	_, err := g.convertContent(context.Background(), llms.MessageContent{Role: schema.ChatMessageTypeSystem})
+	require.ErrorIs(t, err, ErrSystemRoleNotSupported)
+}
+
+func TestGenerateContentInlineDataTooLarge(t *testing.T) {
+	t.Parallel()
+	g := &GoogleAI{opts: defaultOptions()}
+
+	image := llms.BinaryContent{MIMEType: "image/png", Data: make([]byte, 8<<20)}
+	content := []llms.MessageContent{
+		{
+			Role:  schema.ChatMessageTypeHuman,
+			Parts: []llms.ContentPart{image, image},
+		},
+		{
+			Role:  schema.ChatMessageTypeAI,
+			Parts: []llms.ContentPart{llms.TextContent{Text: "Two images"}},
+		},
+		{
+			Role:  schema.ChatMessageTypeHuman,
+			Parts: []llms.ContentPart{image, llms.TextContent{Text: "And another one"}},
+		},
+	}
+
+	_, err := g.GenerateContent(context.Background(), content)
+	require.ErrorIs(t, err, ErrInlineDataTooLarge)
+	assert.Contains(t, err.Error(), "25165824 bytes")
+
+	_, err = g.GenerateContent(context.Background(), []llms.MessageContent{{
+		Role:  schema.ChatMessageTypeHuman,
+		Parts: []llms.ContentPart{image, image, image},
+	}})
+	require.ErrorIs(t, err, ErrInlineDataTooLarge)
+
+	require.NoError(t, checkInlineDataSize(&genai.Content{
+		Parts: []genai.Part{genai.Blob{Data: make([]byte, 8<<20)}, &genai.Blob{Data: make([]byte, 8<<20)}},
+	}))
+}
+
+func TestGenerateContentTooManyParts(t *testing.T) {
+	t.Parallel()
+	g := &GoogleAI{opts: defaultOptions()}
+	WithMaxPartsPerRequest(3)(&g.opts)
+
+	image := llms.BinaryContent{MIMEType: "image/png", Data: []byte("png data")}
+	content := []llms.MessageContent{
+		{Role: schema.ChatMessageTypeHuman, Parts: []llms.ContentPart{image, image}},
+		{Role: schema.ChatMessageTypeAI, Parts: []llms.ContentPart{llms.TextContent{Text: "Two images"}}},
+		{Role: schema.ChatMessageTypeHuman, Parts: []llms.ContentPart{image, llms.TextContent{Text: "And another one"}}},
+	}
+	_, err := g.GenerateContent(context.Background(), content)
+	require.ErrorIs(t, err, ErrTooManyParts)
+	assert.Contains(t, err.Error(), "5 parts exceed the limit of 3")
+
+	require.NoError(t, checkPartCount(3, &genai.Content{Parts: []genai.Part{genai.Text("a")}},
+		&genai.Content{Parts: []genai.Part{genai.Text("b"), genai.Text("c")}}))
+	require.NoError(t, checkPartCount(0, &genai.Content{Parts: make([]genai.Part, 5000)}))
+	require.ErrorIs(t, checkPartCount(defaultMaxPartsPerRequest, &genai.Content{Parts: make([]genai.Part, 5000)}), ErrTooManyParts)
+}
+
+func TestWithUserAgent(t *testing.T) {
+	t.Parallel()
+
+	llm, err := NewGoogleAI(context.Background(), WithAPIKey("key"))
+	require.NoError(t, err)
+	assert.Contains(t, llm.opts.clientOptions(), option.WithUserAgent(defaultUserAgent()))
+	assert.True(t, strings.HasPrefix(defaultUserAgent(), "langchaingo"))
+
+	llm, err = NewGoogleAI(context.Background(), WithAPIKey("key"), WithUserAgent("my-app/1.0"))
+	require.NoError(t, err)
+	assert.Contains(t, llm.opts.clientOptions(), option.WithUserAgent("my-app/1.0"))
+	assert.Contains(t, llm.opts.clientOptions(), option.WithAPIKey("key"))
+}
+
+func TestEstimateCost(t *testing.T) {
+	t.Parallel()
+
+	llm, err := NewGoogleAI(context.Background(), WithAPIKey("key"), WithPricing(map[string]ModelPricing{
+		"gemini-pro":        {InputPerMillion: 0.5, OutputPerMillion: 1.5},
+		"gemini-pro-vision": {InputPerMillion: 1, OutputPerMillion: 2},
+	}))
+	require.NoError(t, err)
+
+	cost, err := llm.EstimateCost(Usage{Model: "gemini-pro", InputTokens: 2_000_000, OutputTokens: 1_000_000})
+	require.NoError(t, err)
+	assert.InDelta(t, 2.5, cost, 1e-9)
+
+	cost, err = llm.EstimateCost(Usage{Model: "models/gemini-pro-vision-001", InputTokens: 500_000, OutputTokens: 250_000})
+	require.NoError(t, err)
+	assert.InDelta(t, 1.0, cost, 1e-9)
+
+	_, err = llm.EstimateCost(Usage{Model: "embedding-001", InputTokens: 10})
+	require.ErrorIs(t, err, ErrNoPricing)
+}
+
+type errReader struct{}
+
+func (errReader) Read([]byte) (int, error) { return 0, errors.New("read failed") }
+
+func TestConvertPartsReaderContent(t *testing.T) {
+	t.Parallel()
+	g := &GoogleAI{}
+
+	parts, err := g.convertParts(context.Background(), []llms.ContentPart{
+		llms.TextContent{Text: "Describe this image"},
+		llms.ReaderContent{MIMEType: "image/png", Reader: strings.NewReader("\x89PNG")},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, genai.Blob{MIMEType: "image/png", Data: []byte("\x89PNG")}, parts[1])
+
+	_, err = g.convertParts(context.Background(), []llms.ContentPart{
+		llms.TextContent{Text: "Describe this image"},
+		llms.ReaderContent{MIMEType: "image/png", Reader: errReader{}},
+	})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "part 1")
+	assert.Contains(t, err.Error(), "read failed")
+
+	_, err = g.convertParts(context.Background(), []llms.ContentPart{
+		llms.ReaderContent{MIMEType: "image/png", Reader: bytes.NewReader(make([]byte, maxInlineDataSize+1))},
+	})
+	require.ErrorIs(t, err, ErrInlineDataTooLarge)
+}
+
+func TestConvertPartsImageURLsConcurrently(t *testing.T) {
+	t.Parallel()
+
+	// Every request waits until all three are in flight, so the test only
+	// passes quickly if the images are downloaded concurrently.
+	const images = 3
+	var arrived sync.WaitGroup
+	arrived.Add(images)
+	allArrived := make(chan struct{})
+	go func() { arrived.Wait(); close(allArrived) }()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		arrived.Done()
+		select {
+		case <-allArrived:
+		case <-time.After(5 * time.Second):
+			w.WriteHeader(http.StatusRequestTimeout)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		_, _ = w.Write([]byte(r.URL.Path))
+	}))
+	defer srv.Close()
+
+	g := &GoogleAI{opts: defaultOptions()}
+	parts, err := g.convertParts(context.Background(), []llms.ContentPart{
+		llms.ImageURLContent{URL: srv.URL + "/a"},
+		llms.TextContent{Text: "Compare these images"},
+		llms.ImageURLContent{URL: srv.URL + "/b"},
+		llms.ImageURLContent{URL: srv.URL + "/c"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []genai.Part{
+		&genai.Blob{MIMEType: "image/png", Data: []byte("/a")},
+		genai.Text("Compare these images"),
+		&genai.Blob{MIMEType: "image/png", Data: []byte("/b")},
+		&genai.Blob{MIMEType: "image/png", Data: []byte("/c")},
+	}, parts)
+}
+
+func TestConvertPartsImageURLsOrder(t *testing.T) {
+	t.Parallel()
+
+	// The downloads finish in the reverse order of the parts: each image waits
+	// for the download of the next one to finish.
+	finished := map[string]chan struct{}{"/a": make(chan struct{}), "/b": make(chan struct{}), "/c": make(chan struct{})}
+	next := map[string]string{"/a": "/b", "/b": "/c"}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if n, ok := next[r.URL.Path]; ok {
+			select {
+			case <-finished[n]:
+			case <-time.After(5 * time.Second):
+				w.WriteHeader(http.StatusRequestTimeout)
+				return
+			}
+		}
+		w.Header().Set("Content-Type", "image/png")
+		_, _ = w.Write([]byte(r.URL.Path))
+		w.(http.Flusher).Flush()
+		close(finished[r.URL.Path])
+	}))
+	defer srv.Close()
+
+	g := &GoogleAI{opts: defaultOptions()}
+	parts, err := g.convertParts(context.Background(), []llms.ContentPart{
+		llms.TextContent{Text: "First"},
+		llms.ImageURLContent{URL: srv.URL + "/a"},
+		llms.TextContent{Text: "Second"},
+		llms.ImageURLContent{URL: srv.URL + "/b"},
+		llms.ImageURLContent{URL: srv.URL + "/c"},
+		llms.TextContent{Text: "Last"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []genai.Part{
+		genai.Text("First"),
+		&genai.Blob{MIMEType: "image/png", Data: []byte("/a")},
+		genai.Text("Second"),
+		&genai.Blob{MIMEType: "image/png", Data: []byte("/b")},
+		&genai.Blob{MIMEType: "image/png", Data: []byte("/c")},
+		genai.Text("Last"),
+	}, parts)
+}
+
+func TestConvertPartsImageURLCanceled(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	g := &GoogleAI{opts: defaultOptions()}
+	_, err := g.convertParts(ctx, []llms.ContentPart{
+		llms.ImageURLContent{URL: srv.URL + "/a"},
+		llms.ImageURLContent{URL: srv.URL + "/b"},
+	})
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestTreatSafetyBlockAsEmpty(t *testing.T) {
+	t.Parallel()
+
+	promptBlocked := &genai.BlockedError{PromptFeedback: &genai.PromptFeedback{BlockReason: genai.BlockReasonSafety}}
+	candidateBlocked := &genai.BlockedError{Candidate: &genai.Candidate{
+		FinishReason:  genai.FinishReasonSafety,
+		SafetyRatings: []*genai.SafetyRating{{Category: genai.HarmCategoryHarassment, Probability: genai.HarmProbabilityHigh}},
+	}}
+
+	g := &GoogleAI{opts: defaultOptions()}
+	_, ok := g.blockedResponse(promptBlocked)
+	assert.False(t, ok)
+
+	WithTreatSafetyBlockAsEmpty()(&g.opts)
+	resp, ok := g.blockedResponse(promptBlocked)
+	require.True(t, ok)
+	require.Len(t, resp.Choices, 1)
+	assert.Empty(t, resp.Choices[0].Content)
+	assert.Equal(t, genai.BlockReasonSafety.String(), resp.Choices[0].GenerationInfo[BLOCK_REASON])
+
+	resp, ok = g.blockedResponse(fmt.Errorf("wrapped: %w", candidateBlocked))
+	require.True(t, ok)
+	assert.Equal(t, genai.FinishReasonSafety.String(), resp.Choices[0].StopReason)
+	assert.Equal(t, genai.FinishReasonSafety.String(), resp.Choices[0].GenerationInfo[BLOCK_REASON])
+	assert.Equal(t, candidateBlocked.Candidate.SafetyRatings, resp.Choices[0].GenerationInfo[SAFETY])
+
+	_, ok = g.blockedResponse(errors.New("other"))
+	assert.False(t, ok)
+
+	resp, err := g.convertCandidates([]*genai.Candidate{{
+		Content:      &genai.Content{Parts: []genai.Part{genai.Text("partial")}},
+		FinishReason: genai.FinishReasonSafety,
+	}})
+	require.NoError(t, err)
+	assert.Empty(t, resp.Choices[0].Content)
+	assert.Equal(t, genai.FinishReasonSafety.String(), resp.Choices[0].GenerationInfo[BLOCK_REASON])
+}
+
+func TestWithQuotaProject(t *testing.T) {
+	t.Parallel()
+
+	_, err := NewGoogleAI(context.Background(), WithAPIKey("key"), WithQuotaProject("billing-project"))
+	require.ErrorIs(t, err, ErrInvalidOptions)
+
+	ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "token"})
+	llm, err := NewGoogleAI(context.Background(), WithTokenSource(ts), WithQuotaProject("billing-project"), WithTelemetryDisabled())
+	require.NoError(t, err)
+	assert.Contains(t, llm.opts.clientOptions(), option.WithQuotaProject("billing-project"))
+	assert.Contains(t, llm.opts.clientOptions(), option.WithTelemetryDisabled())
+}
+
+func TestTrimHistory(t *testing.T) {
+	t.Parallel()
+
+	message := func(role schema.ChatMessageType, text string) llms.MessageContent {
+		return llms.MessageContent{Role: role, Parts: []llms.ContentPart{llms.TextContent{Text: text}}}
+	}
+	messages := []llms.MessageContent{
+		message(schema.ChatMessageTypeSystem, "system"),
+		message(schema.ChatMessageTypeHuman, "q1"),
+		message(schema.ChatMessageTypeAI, "a1"),
+		message(schema.ChatMessageTypeHuman, "q2"),
+		message(schema.ChatMessageTypeAI, "a2"),
+		message(schema.ChatMessageTypeHuman, "q3"),
+	}
+
+	assert.Equal(t, messages, trimHistory(messages, 0))
+	assert.Equal(t, messages, trimHistory(messages, 5))
+	assert.Equal(t, []llms.MessageContent{messages[0], messages[3], messages[4], messages[5]}, trimHistory(messages, 3))
+	// Trimming to an even number would start the history with a model turn.
+	assert.Equal(t, []llms.MessageContent{messages[0], messages[5]}, trimHistory(messages, 2))
+	assert.Equal(t, []llms.MessageContent{messages[0], messages[5]}, trimHistory(messages, 1))
+}
+
+func TestGenerateContentSingleMessageRole(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeFakeAPIResponse(w, r)
+	}))
+	defer srv.Close()
+
+	message := func(role schema.ChatMessageType) []llms.MessageContent {
+		return []llms.MessageContent{{Role: role, Parts: []llms.ContentPart{llms.TextContent{Text: "Hello"}}}}
+	}
+
+	g := newFakeAPIClient(t, srv.URL)
+	for _, role := range []schema.ChatMessageType{"", schema.ChatMessageTypeHuman, schema.ChatMessageTypeGeneric} {
+		resp, err := g.GenerateContent(context.Background(), message(role))
+		require.NoError(t, err, role)
+		assert.Equal(t, "Hi", resp.Choices[0].Content)
+	}
+
+	_, err := g.GenerateContent(context.Background(), message(schema.ChatMessageTypeAI))
+	require.ErrorIs(t, err, ErrLastMessageNotFromUser)
+	_, err = g.GenerateContent(context.Background(), message(schema.ChatMessageTypeSystem))
+	require.ErrorIs(t, err, ErrLastMessageNotFromUser)
+
+	_, err = NewGoogleAI(context.Background(), WithAPIKey("key"), WithSingleMessageRole(schema.ChatMessageTypeAI))
+	require.ErrorIs(t, err, ErrInvalidOptions)
+}
+
+func TestStreamContentSingleMessageRole(t *testing.T) {
+	t.Parallel()
+
+	roles := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Contents []struct {
+				Role string `json:"role"`
+			} `json:"contents"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		roles <- req.Contents[0].Role
+		writeFakeAPIResponse(w, r)
+	}))
+	defer srv.Close()
+
+	g := newFakeAPIClient(t, srv.URL)
+	ch, err := g.StreamContent(context.Background(), []llms.MessageContent{
+		{Parts: []llms.ContentPart{llms.TextContent{Text: "Hello"}}},
+	})
+	require.NoError(t, err)
+
+	var sb strings.Builder
+	for chunk := range ch {
+		sb.WriteString(chunk.Text)
+	}
+	assert.Equal(t, "Hi", sb.String())
+	assert.Equal(t, "user", <-roles)
+
+	_, err = g.StreamContent(context.Background(), []llms.MessageContent{
+		{Role: schema.ChatMessageTypeAI, Parts: []llms.ContentPart{llms.TextContent{Text: "Hello"}}},
+	})
+	require.ErrorIs(t, err, ErrLastMessageNotFromUser)
+}
+
+func TestUpdateAPIKey(t *testing.T) {
+	t.Parallel()
+
+	var keys []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.URL.Query().Get("key")
+		if key == "" {
+			key = r.Header.Get("X-Goog-Api-Key")
+		}
+		keys = append(keys, key)
+		writeFakeAPIResponse(w, r)
+	}))
+	defer srv.Close()
+
+	g := newFakeAPIClient(t, srv.URL)
+	messages := []llms.MessageContent{{
+		Role:  schema.ChatMessageTypeHuman,
+		Parts: []llms.ContentPart{llms.TextContent{Text: "Hello"}},
+	}}
+
+	_, err := g.GenerateContent(context.Background(), messages)
+	require.NoError(t, err)
+	require.NoError(t, g.UpdateAPIKey(context.Background(), "new-key"))
+	_, err = g.GenerateContent(context.Background(), messages)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"key", "new-key"}, keys)
+
+	require.ErrorIs(t, g.UpdateAPIKey(context.Background(), ""), ErrMissingAPIKey)
+
+	g = newFakeAPIClient(t, srv.URL, WithTokenSource(oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "token"})))
+	require.ErrorIs(t, g.UpdateAPIKey(context.Background(), "new-key"), ErrInvalidOptions)
+}
+
+func TestGenerateSingle(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			GenerationConfig struct {
+				CandidateCount int `json:"candidateCount"`
+			} `json:"generationConfig"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		w.Header().Set("Content-Type", "application/json")
+		if req.GenerationConfig.CandidateCount > 1 {
+			_, _ = w.Write([]byte(`{"candidates": [
+				{"content": {"role": "model", "parts": [{"text": "Hi"}]}, "finishReason": "STOP"},
+				{"content": {"role": "model", "parts": [{"text": "Hello"}]}, "finishReason": "STOP"}
+			]}`))
+			return
+		}
+		_, _ = w.Write([]byte(fakeAPIResponse))
+	}))
+	defer srv.Close()
+
+	g := newFakeAPIClient(t, srv.URL)
+	messages := []llms.MessageContent{{
+		Role:  schema.ChatMessageTypeHuman,
+		Parts: []llms.ContentPart{llms.TextContent{Text: "Hello"}},
+	}}
+
+	choice, err := g.GenerateSingle(context.Background(), messages)
+	require.NoError(t, err)
+	assert.Equal(t, "Hi", choice.Content)
+
+	_, err = g.GenerateSingle(context.Background(), messages, llms.WithN(2))
+	require.ErrorIs(t, err, ErrMultipleChoices)
+}
+
+func TestWithSystemMessageFallback(t *testing.T) {
+	t.Parallel()
+
+	var texts []string
+	var mu sync.Mutex
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Contents []struct {
+				Parts []struct {
+					Text string `json:"text"`
+				} `json:"parts"`
+			} `json:"contents"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		mu.Lock()
+		for _, c := range req.Contents {
+			for _, p := range c.Parts {
+				texts = append(texts, p.Text)
+			}
+		}
+		mu.Unlock()
+		writeFakeAPIResponse(w, r)
+	}))
+	defer srv.Close()
+
+	text := func(role schema.ChatMessageType, text string) llms.MessageContent {
+		return llms.MessageContent{Role: role, Parts: []llms.ContentPart{llms.TextContent{Text: text}}}
+	}
+	messages := []llms.MessageContent{
+		text(schema.ChatMessageTypeSystem, "Answer in French."),
+		text(schema.ChatMessageTypeHuman, "Hello"),
+	}
+
+	_, err := newFakeAPIClient(t, srv.URL).GenerateContent(context.Background(), messages)
+	require.ErrorIs(t, err, ErrSystemRoleNotSupported)
+
+	g := newFakeAPIClient(t, srv.URL, WithSystemMessageFallback())
+	resp, err := g.GenerateContent(context.Background(), messages)
+	require.NoError(t, err)
+	assert.Equal(t, "Hi", resp.Choices[0].Content)
+	mu.Lock()
+	assert.Equal(t, []string{"Answer in French.", "Hello"}, texts)
+	mu.Unlock()
+	assert.Len(t, messages[1].Parts, 1, "messages were modified")
+
+	got := prependSystemMessages([]llms.MessageContent{
+		text(schema.ChatMessageTypeSystem, "Answer in French."),
+		text(schema.ChatMessageTypeHuman, "Hello"),
+		text(schema.ChatMessageTypeAI, "Bonjour"),
+		text(schema.ChatMessageTypeSystem, "Be brief."),
+		text(schema.ChatMessageTypeHuman, "How are you?"),
+	})
+	assert.Equal(t, []llms.MessageContent{
+		{Role: schema.ChatMessageTypeHuman, Parts: []llms.ContentPart{
+			llms.TextContent{Text: "Answer in French."},
+			llms.TextContent{Text: "Be brief."},
+			llms.TextContent{Text: "Hello"},
+		}},
+		text(schema.ChatMessageTypeAI, "Bonjour"),
+		text(schema.ChatMessageTypeHuman, "How are you?"),
+	}, got)
+}
+
+func TestGenerateContentRequests(t *testing.T) {
+	t.Parallel()
+
+	var requests []string
+	var mu sync.Mutex
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		requests = append(requests, string(body))
+		mu.Unlock()
+		writeFakeAPIResponse(w, r)
+	}))
+	defer srv.Close()
+	g := newFakeAPIClient(t, srv.URL)
+
+	text := func(role schema.ChatMessageType, text string) llms.MessageContent {
+		return llms.MessageContent{Role: role, Parts: []llms.ContentPart{llms.TextContent{Text: text}}}
+	}
+
+	resp, err := g.GenerateContent(context.Background(), []llms.MessageContent{
+		text(schema.ChatMessageTypeHuman, "Hello"),
+	}, llms.WithN(2))
+	require.NoError(t, err)
+	assert.Equal(t, "Hi", resp.Choices[0].Content)
+
+	// Chat requests are streamed by genai. Depending on the Go version, the
+	// gax stream reader fails to parse the end of the stream, so only the
+	// request is checked.
+	_, _ = g.GenerateContent(context.Background(), []llms.MessageContent{
+		text(schema.ChatMessageTypeHuman, "Hello"),
+		text(schema.ChatMessageTypeAI, "Hi"),
+		text(schema.ChatMessageTypeHuman, "How are you?"),
+	})
+
+	type content struct {
+		Role  string `json:"role"`
+		Parts []struct {
+			Text string `json:"text"`
+		} `json:"parts"`
+	}
+	type request struct {
+		Contents         []content `json:"contents"`
+		GenerationConfig struct {
+			CandidateCount int `json:"candidateCount"`
+		} `json:"generationConfig"`
+	}
+	roles := func(r request) []string {
+		var roles []string
+		for _, c := range r.Contents {
+			roles = append(roles, c.Role+": "+c.Parts[0].Text)
+		}
+		return roles
+	}
+
+	require.Len(t, requests, 2)
+	var single, chat request
+	require.NoError(t, json.Unmarshal([]byte(requests[0]), &single))
+	require.NoError(t, json.Unmarshal([]byte(requests[1]), &chat))
+	assert.Equal(t, []string{"user: Hello"}, roles(single))
+	// A single message is sent without a chat session, so that more than one
+	// candidate can be requested.
+	assert.Equal(t, 2, single.GenerationConfig.CandidateCount)
+	assert.Equal(t, []string{"user: Hello", "model: Hi", "user: How are you?"}, roles(chat))
+}
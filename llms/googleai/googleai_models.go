@@ -0,0 +1,136 @@
+package googleai
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ModelCapabilities describes which features a model supports through this
+// client.
+type ModelCapabilities struct {
+	// Vision is whether the model accepts image input.
+	Vision bool
+	// MultiTurn is whether the model supports multi-turn chat.
+	MultiTurn bool
+	// SystemInstruction is whether the model accepts a system instruction.
+	SystemInstruction bool
+	// Tools is whether the model supports tools (function calling).
+	Tools bool
+	// Embedding is whether the model is an embedding model, which can only be
+	// used with CreateEmbedding and not to generate content.
+	Embedding bool
+	// MultimodalEmbedding is whether the embedding model can embed images as
+	// well as text, with EmbedContentParts.
+	MultimodalEmbedding bool
+}
+
+// modelCapabilities maps model name prefixes to their capabilities. The
+// longest matching prefix of a model name wins, so that versioned names like
+// "gemini-1.0-pro-001" are covered. Neither system instructions nor tools are
+// supported by the genai version this client is built on.
+var modelCapabilities = map[string]ModelCapabilities{ //nolint:gochecknoglobals
+	"gemini-pro":            {MultiTurn: true},
+	"gemini-1.0-pro":        {MultiTurn: true},
+	"gemini-pro-vision":     {Vision: true},
+	"gemini-1.0-pro-vision": {Vision: true},
+	"embedding-001":         {Embedding: true},
+	"text-embedding-004":    {Embedding: true},
+}
+
+// Capabilities returns the capabilities of model, which may be given with or
+// without the "models/" prefix. It reports false if the model is unknown.
+func Capabilities(model string) (ModelCapabilities, bool) {
+	model = strings.TrimPrefix(model, "models/")
+
+	var match string
+	for prefix := range modelCapabilities {
+		if strings.HasPrefix(model, prefix) && len(prefix) > len(match) {
+			match = prefix
+		}
+	}
+	if match == "" {
+		return ModelCapabilities{}, false
+	}
+	return modelCapabilities[match], true
+}
+
+// SupportsSystemInstruction reports whether model is known to accept a system
+// instruction.
+func SupportsSystemInstruction(model string) bool {
+	c, _ := Capabilities(model)
+	return c.SystemInstruction
+}
+
+// SupportsTools reports whether model is known to support tools.
+func SupportsTools(model string) bool {
+	c, _ := Capabilities(model)
+	return c.Tools
+}
+
+// SupportsVision reports whether model is known to accept image input.
+func SupportsVision(model string) bool {
+	c, _ := Capabilities(model)
+	return c.Vision
+}
+
+// SupportsMultiTurn reports whether model is known to support multi-turn
+// chat.
+func SupportsMultiTurn(model string) bool {
+	c, _ := Capabilities(model)
+	return c.MultiTurn
+}
+
+// modelSupporting returns the name of a known model whose capabilities satisfy
+// supports, or "" if there is none. Shorter names are preferred.
+func modelSupporting(supports func(ModelCapabilities) bool) string {
+	var models []string
+	for model, c := range modelCapabilities {
+		if supports(c) {
+			models = append(models, model)
+		}
+	}
+	sort.Slice(models, func(i, j int) bool {
+		if len(models[i]) != len(models[j]) {
+			return len(models[i]) < len(models[j])
+		}
+		return models[i] < models[j]
+	})
+	if len(models) == 0 {
+		return ""
+	}
+	return models[0]
+}
+
+// checkGenerationModel verifies that model isn't known to be an embedding
+// model, which fails with an opaque API error when generating content.
+func checkGenerationModel(model string) error {
+	if c, ok := Capabilities(model); ok && c.Embedding {
+		return fmt.Errorf("%w: %v is an embedding model, use CreateEmbedding with it instead", ErrWrongModelKind, model)
+	}
+	return nil
+}
+
+// checkEmbeddingModel verifies that model isn't known to be a content
+// generation model.
+func checkEmbeddingModel(model string) error {
+	if c, ok := Capabilities(model); ok && !c.Embedding {
+		return fmt.Errorf("%w: %v is not an embedding model, use GenerateContent with it instead or pick an "+
+			"embedding model such as %v", ErrWrongModelKind, model, modelSupporting(func(c ModelCapabilities) bool { return c.Embedding }))
+	}
+	return nil
+}
+
+// ErrMultimodalEmbeddingNotSupported is returned by EmbedContentParts for
+// images when the embedding model only embeds text.
+var ErrMultimodalEmbeddingNotSupported = errors.New("embedding model doesn't support images")
+
+// checkMultimodalEmbeddingModel verifies that model isn't known to be an
+// embedding model that only embeds text.
+func checkMultimodalEmbeddingModel(model string) error {
+	if c, ok := Capabilities(model); ok && c.Embedding && !c.MultimodalEmbedding {
+		return fmt.Errorf("%w: %v", ErrMultimodalEmbeddingNotSupported, model)
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package googleai
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tmc/langchaingo/llms"
+	"github.com/tmc/langchaingo/schema"
+)
+
+func TestCapabilities(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		model     string
+		want      ModelCapabilities
+		wantKnown bool
+	}{
+		{"gemini-pro", ModelCapabilities{MultiTurn: true}, true},
+		{"models/gemini-pro", ModelCapabilities{MultiTurn: true}, true},
+		{"gemini-1.0-pro-001", ModelCapabilities{MultiTurn: true}, true},
+		{"gemini-pro-vision", ModelCapabilities{Vision: true}, true},
+		{"models/embedding-001", ModelCapabilities{Embedding: true}, true},
+		{"unknown-model", ModelCapabilities{}, false},
+	}
+	for _, tt := range tests {
+		got, known := Capabilities(tt.model)
+		assert.Equal(t, tt.want, got, tt.model)
+		assert.Equal(t, tt.wantKnown, known, tt.model)
+	}
+
+	assert.True(t, SupportsVision("gemini-pro-vision"))
+	assert.False(t, SupportsMultiTurn("gemini-pro-vision"))
+	assert.False(t, SupportsSystemInstruction("gemini-pro"))
+	assert.False(t, SupportsTools("gemini-pro"))
+}
+
+func TestGenerateContentMultiTurnNotSupported(t *testing.T) {
+	t.Parallel()
+	g := &GoogleAI{opts: defaultOptions()}
+
+	content := []llms.MessageContent{
+		{
+			Role:  schema.ChatMessageTypeHuman,
+			Parts: []llms.ContentPart{llms.TextContent{Text: "Name some countries"}},
+		},
+		{
+			Role:  schema.ChatMessageTypeAI,
+			Parts: []llms.ContentPart{llms.TextContent{Text: "Spain and Lesotho"}},
+		},
+		{
+			Role:  schema.ChatMessageTypeHuman,
+			Parts: []llms.ContentPart{llms.TextContent{Text: "Which if these is larger?"}},
+		},
+	}
+
+	_, err := g.GenerateContent(context.Background(), content, llms.WithModel("gemini-pro-vision"))
+	require.ErrorIs(t, err, ErrMultiTurnNotSupported)
+}
+
+func TestGenerateContentToolsNotSupported(t *testing.T) {
+	t.Parallel()
+	g := &GoogleAI{opts: defaultOptions()}
+
+	content := []llms.MessageContent{{
+		Role:  schema.ChatMessageTypeHuman,
+		Parts: []llms.ContentPart{llms.TextContent{Text: "What's the weather in Paris?"}},
+	}}
+	functions := []llms.FunctionDefinition{{Name: "getWeather", Description: "Get the weather"}}
+
+	_, err := g.GenerateContent(context.Background(), content, llms.WithFunctions(functions))
+	require.ErrorIs(t, err, ErrToolsNotSupported)
+	assert.Contains(t, err.Error(), "gemini-pro")
+}
+
+func TestModelSupporting(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "gemini-pro-vision", modelSupporting(func(c ModelCapabilities) bool { return c.Vision }))
+	assert.Equal(t, "gemini-pro", modelSupporting(func(c ModelCapabilities) bool { return c.MultiTurn }))
+	assert.Empty(t, modelSupporting(func(c ModelCapabilities) bool { return c.Tools }))
+}
+
+func TestWrongModelKind(t *testing.T) {
+	t.Parallel()
+	g, _ := newFakeEmbeddingClient()
+
+	content := []llms.MessageContent{{
+		Role:  schema.ChatMessageTypeHuman,
+		Parts: []llms.ContentPart{llms.TextContent{Text: "Name some countries"}},
+	}}
+	_, err := g.GenerateContent(context.Background(), content, llms.WithModel("embedding-001"))
+	require.ErrorIs(t, err, ErrWrongModelKind)
+	assert.Contains(t, err.Error(), "CreateEmbedding")
+
+	_, err = g.CreateEmbeddingWithOptions(context.Background(), []string{"text-1"}, WithEmbeddingModel("gemini-pro"))
+	require.ErrorIs(t, err, ErrWrongModelKind)
+	assert.Contains(t, err.Error(), "embedding-001")
+
+	_, err = g.CreateEmbeddingWithOptions(context.Background(), []string{"text-1"}, WithEmbeddingModel("custom-embedder"))
+	require.NoError(t, err)
+}
+
+func TestAllowedModels(t *testing.T) {
+	t.Parallel()
+	g, _ := newFakeEmbeddingClient(WithAllowedModels([]string{"gemini-pro", "models/embedding-001"}))
+
+	content := []llms.MessageContent{{
+		Role:  schema.ChatMessageTypeHuman,
+		Parts: []llms.ContentPart{llms.TextContent{Text: "Name some countries"}},
+	}}
+	_, err := g.GenerateContent(context.Background(), content, llms.WithModel("gemini-pro-vision"))
+	require.ErrorIs(t, err, ErrModelNotAllowed)
+	assert.Contains(t, err.Error(), "gemini-pro-vision")
+	_, err = g.GenerateRaw(context.Background(), "gemini-1.0-pro", nil)
+	require.ErrorIs(t, err, ErrModelNotAllowed)
+
+	_, err = g.CreateEmbedding(context.Background(), []string{"text-1"})
+	require.NoError(t, err)
+	_, err = g.CreateEmbeddingWithOptions(context.Background(), []string{"text-1"}, WithEmbeddingModel("text-embedding-004"))
+	require.ErrorIs(t, err, ErrModelNotAllowed)
+}
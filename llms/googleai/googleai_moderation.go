@@ -0,0 +1,79 @@
+package googleai
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/tmc/langchaingo/llms"
+)
+
+// ModerationResult is the outcome of moderating a text with Moderate.
+type ModerationResult struct {
+	// Blocked reports whether the text was blocked as a prompt.
+	Blocked bool
+	// BlockReason is the reason the text was blocked, if it was.
+	BlockReason genai.BlockReason
+	// SafetyRatings are the safety ratings of the text in every harm category.
+	SafetyRatings []*genai.SafetyRating
+	// Categories are the harm categories the text triggered: those it was
+	// blocked for or rated at least medium probability of harm in.
+	Categories []genai.HarmCategory
+}
+
+// Moderate rates text for safety as a prompt, e.g. to filter user input
+// before generating content from it. The text is submitted with a minimal
+// generation of a single token, and the prompt feedback of the response is
+// returned. Options such as llms.WithModel and WithCallHarmThreshold apply as
+// for GenerateContent. A blocked text isn't an error, but is reported in the
+// result.
+func (g *GoogleAI) Moderate(ctx context.Context, text string, options ...llms.CallOption) (*ModerationResult, error) {
+	if err := g.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer g.release()
+
+	opts := g.callOptions(options...)
+	opts.MaxTokens = 1
+	if err := g.opts.checkModelAllowed(opts.Model); err != nil {
+		return nil, err
+	}
+	if err := checkGenerationModel(opts.Model); err != nil {
+		return nil, err
+	}
+	model := g.generativeModel(opts.Model, &opts)
+
+	var resp *genai.GenerateContentResponse
+	err := g.call(ctx, func() error {
+		var err error
+		resp, err = model.GenerateContent(ctx, genai.Text(text))
+		return err
+	})
+
+	var feedback *genai.PromptFeedback
+	var blocked *genai.BlockedError
+	switch {
+	case errors.As(err, &blocked):
+		// A blocked candidate carries no prompt feedback, but means the
+		// prompt itself passed.
+		feedback = blocked.PromptFeedback
+	case err != nil:
+		return nil, err
+	default:
+		feedback = resp.PromptFeedback
+	}
+
+	result := &ModerationResult{}
+	if feedback == nil {
+		return result, nil
+	}
+	result.Blocked = feedback.BlockReason != genai.BlockReasonUnspecified
+	result.BlockReason = feedback.BlockReason
+	result.SafetyRatings = feedback.SafetyRatings
+	for _, rating := range feedback.SafetyRatings {
+		if rating.Blocked || rating.Probability >= genai.HarmProbabilityMedium {
+			result.Categories = append(result.Categories, rating.Category)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,62 @@
+package googleai
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestModerate(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Contents []struct {
+				Parts []struct {
+					Text string `json:"text"`
+				} `json:"parts"`
+			} `json:"contents"`
+			GenerationConfig struct {
+				MaxOutputTokens int `json:"maxOutputTokens"`
+			} `json:"generationConfig"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		if req.GenerationConfig.MaxOutputTokens != 1 {
+			http.Error(w, "expected a single output token", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if req.Contents[0].Parts[0].Text == "bad" {
+			_, _ = w.Write([]byte(`{"promptFeedback": {"blockReason": "SAFETY", "safetyRatings": [
+				{"category": "HARM_CATEGORY_HARASSMENT", "probability": "HIGH", "blocked": true},
+				{"category": "HARM_CATEGORY_HATE_SPEECH", "probability": "MEDIUM"},
+				{"category": "HARM_CATEGORY_DANGEROUS_CONTENT", "probability": "NEGLIGIBLE"}
+			]}}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"candidates": [{"content": {"role": "model", "parts": [{"text": "Hi"}]}, "finishReason": "MAX_TOKENS"}],
+			"promptFeedback": {"safetyRatings": [{"category": "HARM_CATEGORY_HARASSMENT", "probability": "LOW"}]}}`))
+	}))
+	defer srv.Close()
+
+	g := newFakeAPIClient(t, srv.URL)
+
+	result, err := g.Moderate(context.Background(), "good")
+	require.NoError(t, err)
+	assert.False(t, result.Blocked)
+	assert.Empty(t, result.Categories)
+	assert.Equal(t, []*genai.SafetyRating{{Category: genai.HarmCategoryHarassment, Probability: genai.HarmProbabilityLow}}, result.SafetyRatings)
+
+	result, err = g.Moderate(context.Background(), "bad")
+	require.NoError(t, err)
+	assert.True(t, result.Blocked)
+	assert.Equal(t, genai.BlockReasonSafety, result.BlockReason)
+	assert.Equal(t, []genai.HarmCategory{genai.HarmCategoryHarassment, genai.HarmCategoryHateSpeech}, result.Categories)
+	assert.Len(t, result.SafetyRatings, 3)
+}
@@ -1,13 +1,91 @@
 //nolint:gomnd
 package googleai
 
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/tmc/langchaingo/llms"
+	"github.com/tmc/langchaingo/schema"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/option"
+)
+
+// Environment variables consulted for the API key when WithAPIKey isn't used,
+// in order of precedence.
+const (
+	apiKeyEnvVarName       = "GOOGLE_API_KEY"       //nolint:gosec
+	geminiAPIKeyEnvVarName = "GEMINI_API_KEY"       //nolint:gosec
+	genaiAPIKeyEnvVarName  = "GOOGLE_GENAI_API_KEY" //nolint:gosec
+)
+
+// apiKeyEnvVarNames lists the API key environment variables in order of
+// precedence.
+var apiKeyEnvVarNames = []string{ //nolint:gochecknoglobals
+	apiKeyEnvVarName,
+	geminiAPIKeyEnvVarName,
+	genaiAPIKeyEnvVarName,
+}
+
 // options is a set of options for GoogleAI clients.
 type options struct {
 	apiKey                string
+	tokenSource           oauth2.TokenSource
 	defaultModel          string
 	defaultEmbeddingModel string
 	defaultMaxTokens      int32
 	defaultTemperature    float32
+	safetyReportThreshold genai.HarmProbability
+	safetyFlagThreshold   genai.HarmProbability
+	safetyOnlyIfFlagged   bool
+	harmThreshold         genai.HarmBlockThreshold
+	generationConfig      genai.GenerationConfig
+	citationSegments      bool
+	httpClient            *http.Client
+	userAgent             string
+	quotaProject          string
+	telemetryDisabled     bool
+	pricing               map[string]ModelPricing
+
+	circuitBreakerThreshold int
+	circuitBreakerCooldown  time.Duration
+	maxConcurrentRequests   int
+	emptyResponsePolicy     EmptyResponsePolicy
+	treatSafetyBlockAsEmpty bool
+	maxHistoryMessages      int
+	singleMessageRole       schema.ChatMessageType
+
+	tracerProvider trace.TracerProvider
+	allowedModels  []string
+
+	maxRetries               int
+	retryInitialBackoff      time.Duration
+	retryableErrorClassifier func(error) bool
+
+	autoChunkEmbeddingTokens    int
+	autoTruncateEmbeddingTokens int
+	autoTokenLimits             bool
+	defaultCallOptions          []llms.CallOption
+	proxy                       string
+	streamingBufferSize         int
+	omitResponseMetadata        bool
+	connectionPoolSize          int
+	minChunkInterval            time.Duration
+	maxPartsPerRequest          int
+	modelFallbackChain          []string
+	systemMessageFallback       bool
+
+	// endpoint overrides the API endpoint if set, e.g. with a fake server in
+	// tests.
+	endpoint string
 }
 
 func defaultOptions() options {
@@ -17,18 +95,173 @@ func defaultOptions() options {
 		defaultEmbeddingModel: "embedding-001",
 		defaultMaxTokens:      256,
 		defaultTemperature:    0.5,
+		httpClient:            http.DefaultClient,
+		userAgent:             defaultUserAgent(),
+		retryInitialBackoff:   defaultRetryInitialBackoff,
+		singleMessageRole:     schema.ChatMessageTypeHuman,
+		maxPartsPerRequest:    defaultMaxPartsPerRequest,
 	}
 }
 
+// langchaingoModulePath is the module path used to look up the langchaingo
+// version in the build info.
+const langchaingoModulePath = "github.com/tmc/langchaingo"
+
+// defaultUserAgent returns the user agent identifying langchaingo and, when
+// it is known from the build info, its version.
+func defaultUserAgent() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "langchaingo"
+	}
+	version := info.Main.Version
+	if info.Main.Path != langchaingoModulePath {
+		version = ""
+		for _, dep := range info.Deps {
+			if dep.Path == langchaingoModulePath {
+				version = dep.Version
+				break
+			}
+		}
+	}
+	if version == "" {
+		return "langchaingo"
+	}
+	return "langchaingo/" + version
+}
+
+// ErrInvalidOptions is returned by NewGoogleAI when the client options are
+// inconsistent.
+var ErrInvalidOptions = errors.New("invalid googleai options")
+
+// validate checks the options for settings that would otherwise produce a
+// client that fails on first use.
+func (o *options) validate() error {
+	if o.defaultModel == "" {
+		return fmt.Errorf("%w: default model must not be empty", ErrInvalidOptions)
+	}
+	if o.defaultEmbeddingModel == "" {
+		return fmt.Errorf("%w: default embedding model must not be empty", ErrInvalidOptions)
+	}
+	if o.httpClient == nil {
+		return fmt.Errorf("%w: HTTP client must not be nil", ErrInvalidOptions)
+	}
+	if o.proxy != "" {
+		if _, err := parseProxyURL(o.proxy); err != nil {
+			return err
+		}
+	}
+	if o.circuitBreakerThreshold < 0 || o.circuitBreakerCooldown < 0 {
+		return fmt.Errorf("%w: circuit breaker threshold and cooldown must not be negative", ErrInvalidOptions)
+	}
+	if o.maxConcurrentRequests < 0 {
+		return fmt.Errorf("%w: max concurrent requests must not be negative", ErrInvalidOptions)
+	}
+	if o.harmThreshold < genai.HarmBlockUnspecified || o.harmThreshold > genai.HarmBlockNone {
+		return fmt.Errorf("%w: unknown harm threshold %v", ErrInvalidOptions, o.harmThreshold)
+	}
+	if o.singleMessageRole != schema.ChatMessageTypeHuman && o.singleMessageRole != schema.ChatMessageTypeGeneric {
+		return fmt.Errorf("%w: single message role must be human or generic, got %v", ErrInvalidOptions, o.singleMessageRole)
+	}
+	if o.maxRetries < 0 {
+		return fmt.Errorf("%w: max retries must not be negative", ErrInvalidOptions)
+	}
+	if o.maxHistoryMessages < 0 {
+		return fmt.Errorf("%w: max history messages must not be negative", ErrInvalidOptions)
+	}
+	if o.autoChunkEmbeddingTokens < 0 {
+		return fmt.Errorf("%w: auto chunk embedding tokens must not be negative", ErrInvalidOptions)
+	}
+	if o.autoTruncateEmbeddingTokens < 0 {
+		return fmt.Errorf("%w: auto truncate embedding tokens must not be negative", ErrInvalidOptions)
+	}
+	if o.autoChunkEmbeddingTokens > 0 && o.autoTruncateEmbeddingTokens > 0 {
+		return fmt.Errorf("%w: WithAutoChunkEmbeddings and WithEmbeddingAutoTruncate are mutually exclusive", ErrInvalidOptions)
+	}
+	if o.connectionPoolSize < 0 {
+		return fmt.Errorf("%w: connection pool size must not be negative", ErrInvalidOptions)
+	}
+	if o.minChunkInterval < 0 {
+		return fmt.Errorf("%w: min chunk interval must not be negative", ErrInvalidOptions)
+	}
+	for _, model := range o.modelFallbackChain {
+		if err := o.checkModelAllowed(model); err != nil {
+			return fmt.Errorf("%w: fallback model: %w", ErrInvalidOptions, err)
+		}
+		if err := checkGenerationModel(model); err != nil {
+			return fmt.Errorf("%w: fallback model: %w", ErrInvalidOptions, err)
+		}
+	}
+	if o.maxPartsPerRequest < 0 {
+		return fmt.Errorf("%w: max parts per request must not be negative", ErrInvalidOptions)
+	}
+	if o.streamingBufferSize < 0 {
+		return fmt.Errorf("%w: streaming buffer size must not be negative", ErrInvalidOptions)
+	}
+	if o.emptyResponsePolicy != EmptyResponseError && o.emptyResponsePolicy != EmptyResponseReturnEmpty {
+		return fmt.Errorf("%w: unknown empty response policy %v", ErrInvalidOptions, o.emptyResponsePolicy)
+	}
+	if o.safetyReportThreshold < genai.HarmProbabilityUnspecified || o.safetyReportThreshold > genai.HarmProbabilityHigh {
+		return fmt.Errorf("%w: unknown safety report threshold %v", ErrInvalidOptions, o.safetyReportThreshold)
+	}
+	if o.safetyFlagThreshold < genai.HarmProbabilityUnspecified || o.safetyFlagThreshold > genai.HarmProbabilityHigh {
+		return fmt.Errorf("%w: unknown safety flag threshold %v", ErrInvalidOptions, o.safetyFlagThreshold)
+	}
+	return nil
+}
+
+// checkModelAllowed verifies that model is allowed by WithAllowedModels.
+func (o *options) checkModelAllowed(model string) error {
+	if o.allowedModels == nil {
+		return nil
+	}
+	name := strings.TrimPrefix(model, "models/")
+	for _, allowed := range o.allowedModels {
+		if strings.TrimPrefix(allowed, "models/") == name {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %v; allowed models are %v", ErrModelNotAllowed, model, strings.Join(o.allowedModels, ", "))
+}
+
+// clientOptions returns the options for creating the genai client.
+func (o *options) clientOptions() []option.ClientOption {
+	clientOptions := []option.ClientOption{option.WithAPIKey(o.apiKey)}
+	if o.tokenSource != nil {
+		clientOptions = []option.ClientOption{option.WithTokenSource(o.tokenSource)}
+	}
+	if o.quotaProject != "" {
+		clientOptions = append(clientOptions, option.WithQuotaProject(o.quotaProject))
+	}
+	if o.telemetryDisabled {
+		clientOptions = append(clientOptions, option.WithTelemetryDisabled())
+	}
+	if o.userAgent != "" {
+		clientOptions = append(clientOptions, option.WithUserAgent(o.userAgent))
+	}
+	return clientOptions
+}
+
 type Option func(*options)
 
-// WithAPIKey passes the API KEY (token) to the client.
+// WithAPIKey passes the API KEY (token) to the client. If not set, the key is
+// read from the first non-empty of the GOOGLE_API_KEY, GEMINI_API_KEY and
+// GOOGLE_GENAI_API_KEY environment variables, in that order.
 func WithAPIKey(apiKey string) Option {
 	return func(opts *options) {
 		opts.apiKey = apiKey
 	}
 }
 
+// WithTokenSource passes an OAuth2 token source to authenticate the client
+// with instead of an API key, e.g. for workload identity federation or custom
+// auth brokers. It is mutually exclusive with WithAPIKey.
+func WithTokenSource(tokenSource oauth2.TokenSource) Option {
+	return func(opts *options) {
+		opts.tokenSource = tokenSource
+	}
+}
+
 // WithDefaultModel passes a default content model name to the client. This
 // model name is used if not explicitly provided in specific client invocations.
 func WithDefaultModel(defaultModel string) Option {
@@ -44,3 +277,473 @@ func WithDefaultEmbeddingModel(defaultEmbeddingModel string) Option {
 		opts.defaultEmbeddingModel = defaultEmbeddingModel
 	}
 }
+
+// WithDefaultCallOptions sets call options applied to every call of the
+// client, such as llms.WithTemperature or llms.WithStopWords. They are merged
+// in this order, later ones taking precedence: the client's defaults, such as
+// the model set with WithDefaultModel, then the default call options, then the
+// options passed to the call. Repeated use appends to the default
+// call options.
+func WithDefaultCallOptions(callOptions ...llms.CallOption) Option {
+	return func(opts *options) {
+		opts.defaultCallOptions = append(opts.defaultCallOptions, callOptions...)
+	}
+}
+
+// WithUserAgent sets the User-Agent sent with requests to the Google AI API,
+// e.g. to attribute traffic to an application. It defaults to one identifying
+// langchaingo and its version.
+func WithUserAgent(userAgent string) Option {
+	return func(opts *options) {
+		opts.userAgent = userAgent
+	}
+}
+
+// WithQuotaProject sets the Google Cloud project that requests are billed to
+// and counted against for quota, e.g. to isolate the billing of an
+// application. Requests authenticated with an API key are always attributed to
+// the key's project, so it requires credentials passed with WithTokenSource.
+//
+// This client talks to the Google AI (Gemini API) backend only; for Vertex AI,
+// where the project is part of the client configuration, use the vertexai
+// package instead.
+func WithQuotaProject(project string) Option {
+	return func(opts *options) {
+		opts.quotaProject = project
+	}
+}
+
+// WithTelemetryDisabled disables the OpenCensus and OpenTelemetry
+// instrumentation of the underlying Google API client.
+func WithTelemetryDisabled() Option {
+	return func(opts *options) {
+		opts.telemetryDisabled = true
+	}
+}
+
+// WithPricing passes the per-model token pricing used by EstimateCost, keyed
+// by model name or model name prefix. No pricing is bundled with the client,
+// since published rates change over time.
+func WithPricing(pricing map[string]ModelPricing) Option {
+	return func(opts *options) {
+		opts.pricing = pricing
+	}
+}
+
+// WithCircuitBreaker enables a circuit breaker that stops calling the API
+// after failureThreshold consecutive failed calls. While open, calls fail fast
+// with ErrCircuitOpen; after cooldown a single trial call is let through,
+// closing the circuit on success and opening it again on failure. Use
+// GoogleAI.CircuitState to observe the breaker.
+func WithCircuitBreaker(failureThreshold int, cooldown time.Duration) Option {
+	return func(opts *options) {
+		opts.circuitBreakerThreshold = failureThreshold
+		opts.circuitBreakerCooldown = cooldown
+	}
+}
+
+// WithMaxConcurrentRequests limits the number of concurrent GenerateContent
+// and embedding calls made through the client to n. Further calls block until
+// a call finishes or their context is done. A value of 0 means no limit.
+func WithMaxConcurrentRequests(n int) Option {
+	return func(opts *options) {
+		opts.maxConcurrentRequests = n
+	}
+}
+
+// EmptyResponsePolicy determines how GenerateContent handles responses
+// without any candidates.
+type EmptyResponsePolicy int
+
+const (
+	// EmptyResponseError returns ErrNoContentInResponse. This is the default.
+	EmptyResponseError EmptyResponsePolicy = iota
+	// EmptyResponseReturnEmpty returns a response with a single empty choice,
+	// with the prompt's block reason and safety ratings, if any, in
+	// GenerationInfo[BLOCK_REASON] and GenerationInfo[SAFETY].
+	EmptyResponseReturnEmpty
+)
+
+// WithAllowedModels restricts the models that can be used for generating
+// content and creating embeddings to models, e.g. to govern model use in
+// shared deployments. Calls with any other model fail with ErrModelNotAllowed
+// before reaching the API. Model names may be given with or without the
+// "models/" prefix. The default models have to be allowed explicitly, too.
+func WithAllowedModels(models []string) Option {
+	return func(opts *options) {
+		opts.allowedModels = models
+	}
+}
+
+// WithTracerProvider enables OpenTelemetry tracing with the given tracer
+// provider: every GenerateContent and CreateEmbedding call is recorded as a
+// span with the model name and, for GenerateContent, the finish reasons of
+// the response. Tracing is disabled by default.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(opts *options) {
+		opts.tracerProvider = tp
+	}
+}
+
+// WithRetries makes non-streaming API calls be retried up to maxRetries times
+// when they fail with an error deemed retryable, waiting with exponential
+// backoff starting at one second between attempts. By default, errors are
+// classified with DefaultRetryableErrorClassifier; use
+// WithRetryableErrorClassifier to change that.
+func WithRetries(maxRetries int) Option {
+	return func(opts *options) {
+		opts.maxRetries = maxRetries
+	}
+}
+
+// WithRetryableErrorClassifier sets the function deciding which errors are
+// retried when retries are enabled with WithRetries, e.g. to additionally
+// retry Internal errors. It defaults to DefaultRetryableErrorClassifier.
+func WithRetryableErrorClassifier(isRetryable func(error) bool) Option {
+	return func(opts *options) {
+		opts.retryableErrorClassifier = isRetryable
+	}
+}
+
+// WithEmptyResponsePolicy sets how responses without any candidates are
+// handled; see EmptyResponsePolicy.
+func WithEmptyResponsePolicy(policy EmptyResponsePolicy) Option {
+	return func(opts *options) {
+		opts.emptyResponsePolicy = policy
+	}
+}
+
+// WithTreatSafetyBlockAsEmpty makes GenerateContent return a response with a
+// single empty choice instead of an error when the prompt or the response is
+// blocked for safety reasons, so that a blocked generation doesn't abort a
+// batch. The block reason is reported in GenerationInfo[BLOCK_REASON] and the
+// safety ratings in GenerationInfo[SAFETY].
+func WithTreatSafetyBlockAsEmpty() Option {
+	return func(opts *options) {
+		opts.treatSafetyBlockAsEmpty = true
+	}
+}
+
+// WithSingleMessageRole sets the role assumed for a message without a role
+// when GenerateContent is called with a single message. Gemini only accepts a
+// user turn there, so the role must be human or generic. Defaults to human.
+func WithSingleMessageRole(role schema.ChatMessageType) Option {
+	return func(opts *options) {
+		opts.singleMessageRole = role
+	}
+}
+
+// WithMaxHistoryMessages limits the chat history sent with GenerateContent to
+// the n most recent messages, including the final user message. Older
+// messages are dropped, except for system messages, which are always kept. If
+// the oldest remaining message is a model turn, it is dropped too, since the
+// history has to start with a user turn. A value of 0 means no limit.
+func WithMaxHistoryMessages(n int) Option {
+	return func(opts *options) {
+		opts.maxHistoryMessages = n
+	}
+}
+
+// WithModelFallbackChain sets models to fall back on, in order, when
+// generating content with a call's model fails with an error deemed retryable,
+// once any retries set with WithRetries are exhausted, or because the model is
+// overloaded or not found. Streaming calls only fall back as long as nothing
+// has been streamed yet. The model that served the request is reported in
+// GenerationInfo[MODEL_USED].
+func WithModelFallbackChain(models []string) Option {
+	return func(opts *options) {
+		opts.modelFallbackChain = models
+	}
+}
+
+// WithSystemMessageFallback makes GenerateContent prepend the parts of system
+// messages to the first user message, instead of failing with
+// ErrSystemRoleNotSupported, since the genai version this client is built on
+// has no system instructions. This is a common workaround, but the model takes
+// the system text as part of the user's message: it tends to follow it less
+// strictly than a system instruction, and it can't be told apart from what the
+// user wrote, e.g. if the model quotes it. With WithMaxHistoryMessages, the
+// system text is prepended to the first user message that remains.
+func WithSystemMessageFallback() Option {
+	return func(opts *options) {
+		opts.systemMessageFallback = true
+	}
+}
+
+// WithMaxPartsPerRequest sets the maximum number of content parts, e.g. text
+// and images, across all the messages of a request. Requests with more parts
+// fail with ErrTooManyParts before being sent. It defaults to the API's limit
+// of 3000 images per request; 0 disables the check.
+func WithMaxPartsPerRequest(n int) Option {
+	return func(opts *options) {
+		opts.maxPartsPerRequest = n
+	}
+}
+
+// WithAutoChunkEmbeddings makes CreateEmbedding split texts estimated to be
+// longer than maxTokens tokens into chunks that fit, instead of failing on
+// them. Tokens are estimated at four characters each. The chunks are embedded
+// separately and their vectors pooled into a single embedding by averaging
+// them, weighted by chunk length.
+func WithAutoChunkEmbeddings(maxTokens int) Option {
+	return func(opts *options) {
+		opts.autoChunkEmbeddingTokens = maxTokens
+	}
+}
+
+// WithEmbeddingAutoTruncate makes the embedding calls truncate texts
+// estimated to be longer than maxTokens tokens to fit, instead of failing on
+// them. Tokens are estimated at four characters each, and texts are cut at
+// the last whitespace within the limit where possible. CreateEmbeddingEach
+// reports which texts were truncated. It can't be combined with
+// WithAutoChunkEmbeddings.
+func WithEmbeddingAutoTruncate(maxTokens int) Option {
+	return func(opts *options) {
+		opts.autoTruncateEmbeddingTokens = maxTokens
+	}
+}
+
+// WithHTTPClient passes the HTTP client used to download images referenced by
+// llms.ImageURLContent parts. The client's transport is honored, so a custom
+// dialer can be used, e.g. to force IPv4 in networks where IPv6 resolution of
+// image hosts hangs:
+//
+//	dialer := &net.Dialer{}
+//	client := &http.Client{Transport: &http.Transport{
+//		DialContext: func(ctx context.Context, _, addr string) (net.Conn, error) {
+//			return dialer.DialContext(ctx, "tcp4", addr)
+//		},
+//	}}
+//
+// It does not affect requests to the Google AI API. Defaults to
+// http.DefaultClient.
+func WithHTTPClient(client *http.Client) Option {
+	return func(opts *options) {
+		opts.httpClient = client
+	}
+}
+
+// WithProxy routes requests through the HTTP, HTTPS or SOCKS5 proxy at
+// proxyURL, e.g. "http://proxy.example.com:3128", both to the Google AI API
+// and for downloading the images of ImageURLContent parts. An HTTP client set
+// with WithHTTPClient is used as is for the downloads. NewGoogleAI fails with
+// ErrInvalidOptions if proxyURL is malformed.
+func WithProxy(proxyURL string) Option {
+	return func(opts *options) {
+		opts.proxy = proxyURL
+	}
+}
+
+// parseProxyURL parses the URL of a proxy set with WithProxy.
+func parseProxyURL(proxyURL string) (*url.URL, error) {
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: proxy URL %q: %w", ErrInvalidOptions, proxyURL, err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("%w: proxy URL %q must have an http, https or socks5 scheme", ErrInvalidOptions, proxyURL)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("%w: proxy URL %q has no host", ErrInvalidOptions, proxyURL)
+	}
+	return u, nil
+}
+
+// WithConnectionPoolSize sets the number of idle connections to the Google AI
+// API kept for reuse. The genai client talks to the API over HTTP rather than
+// gRPC, so this sizes the HTTP connection pool. By default, as with the
+// google-api clients, 100 connections are kept; a smaller size saves memory
+// and file descriptors, on both ends, for clients making few concurrent
+// requests, while a larger one avoids setting up and tearing down connections
+// for clients making more.
+func WithConnectionPoolSize(size int) Option {
+	return func(opts *options) {
+		opts.connectionPoolSize = size
+	}
+}
+
+// defaultMaxIdleConnsPerHost is the number of idle connections to the Google
+// AI API kept for reuse by default, as by the transport of the google-api
+// clients.
+const defaultMaxIdleConnsPerHost = 100
+
+// apiTransport returns the transport for requests to the Google AI API: a copy
+// of http.DefaultTransport keeping defaultMaxIdleConnsPerHost idle
+// connections, configured according to WithProxy and WithConnectionPoolSize.
+// If http.DefaultTransport was replaced with another http.RoundTripper, a new
+// transport with the same defaults is used instead.
+func (o *options) apiTransport() *http.Transport {
+	transport, ok := http.DefaultTransport.(*http.Transport)
+	if ok {
+		transport = transport.Clone()
+	} else {
+		transport = newDefaultTransport()
+	}
+	transport.MaxIdleConnsPerHost = defaultMaxIdleConnsPerHost
+	if o.proxy != "" {
+		proxyURL, _ := parseProxyURL(o.proxy) // checked by validate
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+	if o.connectionPoolSize > 0 {
+		transport.MaxIdleConnsPerHost = o.connectionPoolSize
+		transport.MaxIdleConns = max(transport.MaxIdleConns, o.connectionPoolSize)
+	}
+	return transport
+}
+
+// newDefaultTransport returns a transport configured like the original
+// http.DefaultTransport.
+func newDefaultTransport() *http.Transport {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	return &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+}
+
+// WithCitationSegments makes responses additionally report their content as
+// a list of CitationSegment in GenerationInfo[SEGMENTS], each aligned with the
+// citation sources covering it. This allows rendering inline citations.
+func WithCitationSegments() Option {
+	return func(opts *options) {
+		opts.citationSegments = true
+	}
+}
+
+// WithGenerationConfig passes a base generation config to the client. It is
+// used for every GenerateContent call, with the non-zero call options (max
+// tokens, temperature, top-p, top-k, candidate count and stop words) applied
+// on top of it. The client's default max tokens and temperature are only used
+// when config leaves them unset.
+func WithGenerationConfig(config genai.GenerationConfig) Option {
+	return func(opts *options) {
+		opts.generationConfig = config
+	}
+}
+
+// WithHarmThreshold sets the threshold for blocking harmful content in all
+// GenerateContent calls, for all harm categories. It can be overridden for a
+// single call with WithCallHarmThreshold. If not set, the API's default
+// thresholds apply.
+func WithHarmThreshold(threshold genai.HarmBlockThreshold) Option {
+	return func(opts *options) {
+		opts.harmThreshold = threshold
+	}
+}
+
+// WithSafetyReportThreshold makes responses report only the safety ratings
+// whose probability is at or above threshold in GenerationInfo[SAFETY]. The
+// complete list of ratings remains available in GenerationInfo[SAFETY_ALL].
+func WithSafetyReportThreshold(threshold genai.HarmProbability) Option {
+	return func(opts *options) {
+		opts.safetyReportThreshold = threshold
+	}
+}
+
+// WithSafetyRatingsOnlyIfFlagged makes responses include safety ratings, in
+// GenerationInfo[SAFETY] and GenerationInfo[SAFETY_ALL], only if at least one
+// of them blocked the content or has a probability at or above threshold,
+// omitting them in the common all-clear case. With a threshold of
+// genai.HarmProbabilityUnspecified, ratings are only included if one blocked
+// the content. By default, safety ratings are always included.
+func WithSafetyRatingsOnlyIfFlagged(threshold genai.HarmProbability) Option {
+	return func(opts *options) {
+		opts.safetyOnlyIfFlagged = true
+		opts.safetyFlagThreshold = threshold
+	}
+}
+
+// embeddingOptions is a set of options for a single embedding call.
+type embeddingOptions struct {
+	model    string
+	taskType genai.TaskType
+	title    string
+}
+
+// EmbeddingOption configures a single CreateEmbeddingWithOptions call.
+type EmbeddingOption func(*embeddingOptions)
+
+// WithEmbeddingModel sets the embedding model for a single call, overriding
+// the client's default embedding model.
+func WithEmbeddingModel(model string) EmbeddingOption {
+	return func(opts *embeddingOptions) {
+		opts.model = model
+	}
+}
+
+// WithEmbeddingTaskType sets the task the embeddings of a single call are
+// used for, e.g. genai.TaskTypeRetrievalQuery for search queries and
+// genai.TaskTypeRetrievalDocument for the documents searched, which improves
+// their quality for that task.
+func WithEmbeddingTaskType(taskType genai.TaskType) EmbeddingOption {
+	return func(opts *embeddingOptions) {
+		opts.taskType = taskType
+	}
+}
+
+// WithEmbeddingTitle sets the title of the texts embedded in a single call,
+// which improves embedding quality for retrieval. Titles are only supported
+// for genai.TaskTypeRetrievalDocument, which is used when no task type is
+// set. See EmbedDocumentsWithMetadata for embedding documents with different
+// titles.
+func WithEmbeddingTitle(title string) EmbeddingOption {
+	return func(opts *embeddingOptions) {
+		opts.title = title
+	}
+}
+
+// WithAutoTokenLimits makes GenerateContent configure itself from the token
+// limits of the model, as returned by ModelTokenLimits. If neither the call
+// nor the generation config set max tokens, the model's output token limit is
+// used instead of the default max tokens, and a warning is logged when the
+// prompt, estimated at four characters per token, reaches 90% of the model's
+// input token limit.
+func WithAutoTokenLimits() Option {
+	return func(opts *options) {
+		opts.autoTokenLimits = true
+	}
+}
+
+// WithStreamingBufferSize makes streaming calls collect the streamed text and
+// pass it to the streaming function in chunks of at least size bytes, rather
+// than for every piece of text received, reducing the number of callbacks for
+// token-level streams. Whatever remains is passed on when the stream ends. A
+// size of 0, the default, disables buffering.
+func WithStreamingBufferSize(size int) Option {
+	return func(opts *options) {
+		opts.streamingBufferSize = size
+	}
+}
+
+// WithMinChunkInterval makes streaming calls pass the streamed text to the
+// streaming function at most every interval, collecting the text received in
+// between, e.g. to smooth rendering in chat UIs. Whatever remains is passed on
+// when the stream ends. Combined with WithStreamingBufferSize, text is passed
+// on once both the buffer size and the interval are reached. An interval of
+// 0, the default, disables throttling.
+func WithMinChunkInterval(interval time.Duration) Option {
+	return func(opts *options) {
+		opts.minChunkInterval = interval
+	}
+}
+
+// WithOmitResponseMetadata makes responses leave out the safety ratings and
+// citation metadata of their choices, GenerationInfo[SAFETY],
+// GenerationInfo[SAFETY_ALL] and GenerationInfo[CITATIONS], saving their
+// allocation for applications that only need the text. Other generation info,
+// such as that requested with WithCitationSegments, is still reported.
+func WithOmitResponseMetadata() Option {
+	return func(opts *options) {
+		opts.omitResponseMetadata = true
+	}
+}
@@ -0,0 +1,36 @@
+package googleai
+
+import (
+	"github.com/google/generative-ai-go/genai"
+	"github.com/tmc/langchaingo/llms"
+)
+
+// modelParametersMetadataKey is the llms.CallOptions metadata key under which
+// WithModelParameters stores the per-call model parameters.
+const modelParametersMetadataKey = "googleai.model_parameters"
+
+// ModelParameters bundles Gemini-specific parameters for a single call that
+// have no equivalent in llms.CallOptions. Unset fields leave the client's
+// configuration in place.
+type ModelParameters struct {
+	// GenerationConfig replaces the client's WithGenerationConfig for the call.
+	// The generic call options, like llms.WithTemperature, are still applied
+	// on top of it.
+	GenerationConfig *genai.GenerationConfig
+	// SafetySettings replace the safety settings derived from
+	// WithCallHarmThreshold and WithHarmThreshold for the call.
+	SafetySettings []*genai.SafetySetting
+}
+
+// WithModelParameters sets Gemini-specific parameters for a single
+// GenerateContent call.
+func WithModelParameters(params ModelParameters) llms.CallOption {
+	return llms.WithMetadata(modelParametersMetadataKey, params)
+}
+
+// modelParameters returns the parameters passed with WithModelParameters, if
+// any.
+func modelParameters(opts *llms.CallOptions) (ModelParameters, bool) {
+	params, ok := opts.Metadata[modelParametersMetadataKey].(ModelParameters)
+	return params, ok
+}
@@ -0,0 +1,74 @@
+package googleai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrAuthFailed is returned by Ping when the API rejects the client's
+// credentials. The error also wraps ErrInvalidAPIKey or ErrPermissionDenied.
+var ErrAuthFailed = errors.New("authentication with the Google AI API failed")
+
+var (
+	// ErrInvalidAPIKey is wrapped around API errors rejecting the client's
+	// credentials as invalid (Unauthenticated, or a bad request naming the
+	// API key): check the API key.
+	ErrInvalidAPIKey = errors.New("invalid API key")
+	// ErrPermissionDenied is wrapped around API errors rejecting valid
+	// credentials (PermissionDenied): enable the API for the project, or grant
+	// the credentials access.
+	ErrPermissionDenied = errors.New("permission denied")
+)
+
+// Ping checks that the Google AI API can be reached with the client's
+// credentials, by listing models, e.g. to fail fast at startup or for health
+// checks. If the API rejects the credentials, the error wraps ErrAuthFailed.
+func (g *GoogleAI) Ping(ctx context.Context) error {
+	_, err := g.genaiClient().ListModels(ctx).Next()
+	if err == nil || errors.Is(err, iterator.Done) {
+		return nil
+	}
+	if authErr := authError(err); authErr != nil {
+		return fmt.Errorf("%w: %w: %w", ErrAuthFailed, authErr, err)
+	}
+	return err
+}
+
+// authError returns ErrInvalidAPIKey or ErrPermissionDenied if err is the API
+// rejecting the credentials of a request, and nil otherwise. An invalid API
+// key is reported as a bad request.
+func authError(err error) error {
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		switch apiErr.Code {
+		case http.StatusUnauthorized:
+			return ErrInvalidAPIKey
+		case http.StatusForbidden:
+			return ErrPermissionDenied
+		case http.StatusBadRequest:
+			if strings.Contains(apiErr.Message, "API key") {
+				return ErrInvalidAPIKey
+			}
+		}
+		return nil
+	}
+	switch status.Code(err) { //nolint:exhaustive
+	case codes.Unauthenticated:
+		return ErrInvalidAPIKey
+	case codes.PermissionDenied:
+		return ErrPermissionDenied
+	case codes.InvalidArgument:
+		if strings.Contains(status.Convert(err).Message(), "API key") {
+			return ErrInvalidAPIKey
+		}
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package googleai
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestPing(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		status   int
+		body     string
+		wantErr  bool
+		wantAuth error
+	}{
+		{"ok", http.StatusOK, fakeModelList, false, nil},
+		{"invalid API key", http.StatusBadRequest, `{"error": {"code": 400, "message": "API key not valid. Please pass a valid API key.", "status": "INVALID_ARGUMENT"}}`, true, ErrInvalidAPIKey},
+		{"permission denied", http.StatusForbidden, `{"error": {"code": 403, "message": "Permission denied.", "status": "PERMISSION_DENIED"}}`, true, ErrPermissionDenied},
+		{"server error", http.StatusInternalServerError, `{"error": {"code": 500, "message": "Internal error.", "status": "INTERNAL"}}`, true, nil},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+
+			err := newFakeAPIClient(t, srv.URL).Ping(context.Background())
+			if !tt.wantErr {
+				require.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.Equal(t, tt.wantAuth != nil, errors.Is(err, ErrAuthFailed))
+			if tt.wantAuth != nil {
+				require.ErrorIs(t, err, tt.wantAuth)
+			}
+		})
+	}
+}
+
+func TestAuthError(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		err  error
+		want error
+	}{
+		{status.Error(codes.Unauthenticated, "Request had invalid authentication credentials."), ErrInvalidAPIKey},
+		{status.Error(codes.InvalidArgument, "API key not valid. Please pass a valid API key."), ErrInvalidAPIKey},
+		{status.Error(codes.PermissionDenied, "Generative Language API has not been used in project 42."), ErrPermissionDenied},
+		{status.Error(codes.InvalidArgument, "Invalid request."), nil},
+		{status.Error(codes.Internal, "Internal error."), nil},
+		{&googleapi.Error{Code: http.StatusUnauthorized}, ErrInvalidAPIKey},
+		{&googleapi.Error{Code: http.StatusForbidden}, ErrPermissionDenied},
+		{errors.New("other"), nil},
+	}
+	for _, tt := range tests {
+		err := classifyAPIError(tt.err)
+		require.ErrorIs(t, err, tt.err)
+		if tt.want == nil {
+			assert.NotErrorIs(t, err, ErrInvalidAPIKey, tt.err)
+			assert.NotErrorIs(t, err, ErrPermissionDenied, tt.err)
+			continue
+		}
+		require.ErrorIs(t, err, tt.want, tt.err)
+	}
+
+	// Calls wrap the error.
+	m := &failingEmbeddingModel{failures: 1, err: status.Error(codes.PermissionDenied, "denied")}
+	_, err := newFailingEmbeddingClient(m).CreateEmbedding(context.Background(), []string{"text-1"})
+	require.ErrorIs(t, err, ErrPermissionDenied)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
@@ -0,0 +1,51 @@
+package googleai
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrNoPricing is returned by EstimateCost when no pricing is configured for
+// the model.
+var ErrNoPricing = errors.New("no pricing for model")
+
+// Usage is the number of tokens used by a request.
+type Usage struct {
+	// Model is the name of the model the request was made to.
+	Model string
+	// InputTokens is the number of prompt tokens.
+	InputTokens int
+	// OutputTokens is the number of generated tokens.
+	OutputTokens int
+}
+
+// ModelPricing is the price of a model's tokens, in an arbitrary currency, per
+// one million tokens.
+type ModelPricing struct {
+	InputPerMillion  float64
+	OutputPerMillion float64
+}
+
+// EstimateCost returns the approximate cost of usage according to the pricing
+// passed with WithPricing. The pricing of the longest model name prefix
+// matching usage.Model is used, so that e.g. pricing for "gemini-pro" covers
+// "gemini-pro-001". It returns ErrNoPricing if no pricing matches.
+func (g *GoogleAI) EstimateCost(usage Usage) (float64, error) {
+	model := strings.TrimPrefix(usage.Model, "models/")
+
+	var match string
+	for prefix := range g.opts.pricing {
+		if strings.HasPrefix(model, prefix) && len(prefix) > len(match) {
+			match = prefix
+		}
+	}
+	pricing, ok := g.opts.pricing[match]
+	if !ok {
+		return 0, fmt.Errorf("%w %q", ErrNoPricing, usage.Model)
+	}
+
+	const million = 1_000_000
+	return (float64(usage.InputTokens)*pricing.InputPerMillion +
+		float64(usage.OutputTokens)*pricing.OutputPerMillion) / million, nil
+}
@@ -0,0 +1,49 @@
+package googleai
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+// RedactMessageContent returns a copy of messages that is safe to log: binary
+// and image data is replaced by a text placeholder noting its MIME type and
+// size, so that large or sensitive blobs don't end up in logs. Text parts and
+// image URLs other than data URLs are kept as they are. messages isn't
+// modified.
+func RedactMessageContent(messages []llms.MessageContent) []llms.MessageContent {
+	redacted := make([]llms.MessageContent, len(messages))
+	for i, mc := range messages {
+		parts := make([]llms.ContentPart, len(mc.Parts))
+		for j, part := range mc.Parts {
+			parts[j] = redactPart(part)
+		}
+		redacted[i] = llms.MessageContent{Role: mc.Role, Parts: parts}
+	}
+	return redacted
+}
+
+// redactPart returns part, or a placeholder for it if it holds data.
+func redactPart(part llms.ContentPart) llms.ContentPart {
+	switch p := part.(type) {
+	case llms.BinaryContent:
+		return redactedData(p.MIMEType, fmt.Sprintf("%d bytes", len(p.Data)))
+	case llms.ReaderContent:
+		return redactedData(p.MIMEType, "unknown size")
+	case llms.ImageURLContent:
+		if header, data, ok := strings.Cut(p.URL, ","); ok && strings.HasPrefix(header, "data:") {
+			mimeType, _, _ := strings.Cut(strings.TrimPrefix(header, "data:"), ";")
+			return redactedData(mimeType, fmt.Sprintf("%d bytes in data URL", len(data)))
+		}
+	}
+	return part
+}
+
+// redactedData returns the placeholder for data of mimeType.
+func redactedData(mimeType, size string) llms.TextContent {
+	if mimeType == "" {
+		mimeType = "unknown type"
+	}
+	return llms.TextContent{Text: fmt.Sprintf("[redacted %s, %s]", mimeType, size)}
+}
@@ -0,0 +1,39 @@
+package googleai
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tmc/langchaingo/llms"
+	"github.com/tmc/langchaingo/schema"
+)
+
+func TestRedactMessageContent(t *testing.T) {
+	t.Parallel()
+
+	messages := []llms.MessageContent{{
+		Role: schema.ChatMessageTypeHuman,
+		Parts: []llms.ContentPart{
+			llms.TextContent{Text: "What's in these images?"},
+			llms.BinaryContent{MIMEType: "image/png", Data: make([]byte, 1234)},
+			llms.ReaderContent{MIMEType: "application/pdf", Reader: strings.NewReader("%PDF")},
+			llms.ImageURLContent{URL: "data:image/jpeg;base64,aGVsbG8="},
+			llms.ImageURLContent{URL: "https://example.com/cat.png"},
+		},
+	}}
+
+	redacted := RedactMessageContent(messages)
+
+	assert.Equal(t, []llms.MessageContent{{
+		Role: schema.ChatMessageTypeHuman,
+		Parts: []llms.ContentPart{
+			llms.TextContent{Text: "What's in these images?"},
+			llms.TextContent{Text: "[redacted image/png, 1234 bytes]"},
+			llms.TextContent{Text: "[redacted application/pdf, unknown size]"},
+			llms.TextContent{Text: "[redacted image/jpeg, 8 bytes in data URL]"},
+			llms.ImageURLContent{URL: "https://example.com/cat.png"},
+		},
+	}}, redacted)
+	assert.IsType(t, llms.BinaryContent{}, messages[0].Parts[1], "the input must not be modified")
+}
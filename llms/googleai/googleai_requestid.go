@@ -0,0 +1,83 @@
+package googleai
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+// REQUEST_ID is the GenerationInfo key under which the ID of the API request
+// that produced a response is reported, when the API returns one. It helps
+// correlating failures with Google-side logs when filing support tickets.
+const REQUEST_ID = "request_id" //nolint:revive,stylecheck
+
+// requestIDHeaders are the response headers the request ID is read from, in
+// order of precedence.
+var requestIDHeaders = []string{ //nolint:gochecknoglobals
+	"X-Request-Id",
+	"X-Goog-Request-Id",
+	"X-Cloud-Trace-Context",
+}
+
+type requestIDRecorderKey struct{}
+
+// requestIDRecorder records the ID of the last API request made with a
+// context.
+type requestIDRecorder struct {
+	mu sync.Mutex
+	id string
+}
+
+// withRequestIDRecorder returns a context that records the IDs of the API
+// requests made with it into the returned recorder.
+func withRequestIDRecorder(ctx context.Context) (context.Context, *requestIDRecorder) {
+	recorder := &requestIDRecorder{}
+	return context.WithValue(ctx, requestIDRecorderKey{}, recorder), recorder
+}
+
+func (r *requestIDRecorder) record(header http.Header) {
+	for _, name := range requestIDHeaders {
+		if id := header.Get(name); id != "" {
+			r.mu.Lock()
+			r.id = id
+			r.mu.Unlock()
+			return
+		}
+	}
+}
+
+// annotate adds the recorded request ID, if any, to the GenerationInfo of the
+// choices of resp.
+func (r *requestIDRecorder) annotate(resp *llms.ContentResponse) {
+	r.mu.Lock()
+	id := r.id
+	r.mu.Unlock()
+	if resp == nil || id == "" {
+		return
+	}
+	for _, choice := range resp.Choices {
+		if choice.GenerationInfo == nil {
+			choice.GenerationInfo = make(map[string]any)
+		}
+		choice.GenerationInfo[REQUEST_ID] = id
+	}
+}
+
+// requestIDTransport records the request ID of API responses into the
+// requestIDRecorder of the request context, if any.
+type requestIDTransport struct {
+	base http.RoundTripper
+}
+
+func (t requestIDTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+	if recorder, ok := req.Context().Value(requestIDRecorderKey{}).(*requestIDRecorder); ok {
+		recorder.record(resp.Header)
+	}
+	return resp, nil
+}
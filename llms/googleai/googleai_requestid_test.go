@@ -0,0 +1,46 @@
+package googleai
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tmc/langchaingo/llms"
+	"github.com/tmc/langchaingo/schema"
+)
+
+func TestGenerateContentRequestID(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Request-Id", "request-42")
+		writeFakeAPIResponse(w, r)
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	g := newFakeAPIClient(t, srv.URL)
+
+	resp, err := g.GenerateContent(ctx, []llms.MessageContent{{
+		Role:  schema.ChatMessageTypeHuman,
+		Parts: []llms.ContentPart{llms.TextContent{Text: "Hello"}},
+	}})
+	require.NoError(t, err)
+	assert.Equal(t, "Hi", resp.Choices[0].Content)
+	assert.Equal(t, "request-42", resp.Choices[0].GenerationInfo[REQUEST_ID])
+}
+
+func TestRequestIDRecorderWithoutID(t *testing.T) {
+	t.Parallel()
+
+	_, recorder := withRequestIDRecorder(context.Background())
+	recorder.record(http.Header{"Content-Type": []string{"application/json"}})
+
+	resp := &llms.ContentResponse{Choices: []*llms.ContentChoice{{GenerationInfo: map[string]any{}}}}
+	recorder.annotate(resp)
+	assert.NotContains(t, resp.Choices[0].GenerationInfo, REQUEST_ID)
+	recorder.annotate(nil)
+}
@@ -0,0 +1,104 @@
+package googleai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	defaultRetryInitialBackoff = time.Second
+	maxRetryBackoff            = 30 * time.Second
+)
+
+// ErrModelOverloaded is wrapped around API errors reporting that the model is
+// overloaded, a kind of unavailability (Unavailable, HTTP 503) that tends to
+// last longer than others, so that callers can back off for longer.
+var ErrModelOverloaded = errors.New("model is overloaded")
+
+// DefaultRetryableErrorClassifier reports whether err is worth retrying: it
+// is for rate limiting (ResourceExhausted, HTTP 429) and unavailability
+// (Unavailable, HTTP 503) errors, including ErrModelOverloaded.
+func DefaultRetryableErrorClassifier(err error) bool {
+	if errors.Is(err, ErrModelOverloaded) {
+		return true
+	}
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Code == http.StatusTooManyRequests || apiErr.Code == http.StatusServiceUnavailable
+	}
+	switch status.Code(err) { //nolint:exhaustive
+	case codes.ResourceExhausted, codes.Unavailable:
+		return true
+	default:
+		return false
+	}
+}
+
+// call makes an API call with fn, guarded by the circuit breaker. Failed calls
+// are retried up to the number of times set with WithRetries, as long as the
+// retryable error classifier accepts the error, with exponential backoff
+// between attempts. fn must be safe to call repeatedly.
+func (g *GoogleAI) call(ctx context.Context, fn func() error) error {
+	backoff := g.opts.retryInitialBackoff
+	for attempt := 0; ; attempt++ {
+		if err := g.breaker.allow(); err != nil {
+			return err
+		}
+		err := classifyAPIError(fn())
+		g.breaker.record(err)
+		if err == nil || attempt >= g.opts.maxRetries || !g.retryable(err) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, maxRetryBackoff)
+	}
+}
+
+// retryable reports whether err should be retried according to the client's
+// classifier.
+func (g *GoogleAI) retryable(err error) bool {
+	if g.opts.retryableErrorClassifier != nil {
+		return g.opts.retryableErrorClassifier(err)
+	}
+	return DefaultRetryableErrorClassifier(err)
+}
+
+// classifyAPIError wraps ErrInvalidAPIKey or ErrPermissionDenied around err
+// if it is the API rejecting the credentials, and ErrModelOverloaded if it is
+// the API reporting that the model is overloaded, which is only told apart
+// from other unavailability by its message.
+func classifyAPIError(err error) error {
+	if err == nil || errors.Is(err, ErrModelOverloaded) || errors.Is(err, ErrInvalidAPIKey) || errors.Is(err, ErrPermissionDenied) {
+		return err
+	}
+	if authErr := authError(err); authErr != nil {
+		return fmt.Errorf("%w: %w", authErr, err)
+	}
+	var message string
+	var apiErr *googleapi.Error
+	switch {
+	case errors.As(err, &apiErr) && apiErr.Code == http.StatusServiceUnavailable:
+		message = apiErr.Message
+	case status.Code(err) == codes.Unavailable:
+		message = status.Convert(err).Message()
+	default:
+		return err
+	}
+	if !strings.Contains(strings.ToLower(message), "overloaded") {
+		return err
+	}
+	return fmt.Errorf("%w: %w", ErrModelOverloaded, err)
+}
@@ -0,0 +1,115 @@
+package googleai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// failingEmbeddingModel fails the first failures calls with err.
+type failingEmbeddingModel struct {
+	fakeEmbeddingModel
+	failures int
+	err      error
+	calls    int
+}
+
+func (m *failingEmbeddingModel) EmbedContent(ctx context.Context, parts ...genai.Part) (*genai.EmbedContentResponse, error) {
+	m.calls++
+	if m.calls <= m.failures {
+		return nil, m.err
+	}
+	return m.fakeEmbeddingModel.EmbedContent(ctx, parts...)
+}
+
+func newFailingEmbeddingClient(m *failingEmbeddingModel, opts ...Option) *GoogleAI {
+	g := &GoogleAI{opts: defaultOptions()}
+	g.opts.retryInitialBackoff = time.Millisecond
+	for _, opt := range opts {
+		opt(&g.opts)
+	}
+	g.newEmbeddingModel = func(string, genai.TaskType) embeddingModel { return m }
+	return g
+}
+
+func TestDefaultRetryableErrorClassifier(t *testing.T) {
+	t.Parallel()
+
+	assert.True(t, DefaultRetryableErrorClassifier(&googleapi.Error{Code: http.StatusTooManyRequests}))
+	assert.True(t, DefaultRetryableErrorClassifier(&googleapi.Error{Code: http.StatusServiceUnavailable}))
+	assert.False(t, DefaultRetryableErrorClassifier(&googleapi.Error{Code: http.StatusInternalServerError}))
+	assert.True(t, DefaultRetryableErrorClassifier(status.Error(codes.ResourceExhausted, "quota")))
+	assert.True(t, DefaultRetryableErrorClassifier(status.Error(codes.Unavailable, "down")))
+	assert.False(t, DefaultRetryableErrorClassifier(status.Error(codes.Internal, "oops")))
+	assert.False(t, DefaultRetryableErrorClassifier(errors.New("other")))
+	assert.True(t, DefaultRetryableErrorClassifier(fmt.Errorf("%w: %w", ErrModelOverloaded, errors.New("other"))))
+}
+
+func TestModelOverloaded(t *testing.T) {
+	t.Parallel()
+
+	overloaded := &googleapi.Error{Code: http.StatusServiceUnavailable, Message: "The model is overloaded. Please try again later."}
+	require.ErrorIs(t, classifyAPIError(overloaded), ErrModelOverloaded)
+	require.ErrorIs(t, classifyAPIError(status.Error(codes.Unavailable, "The model is overloaded.")), ErrModelOverloaded)
+	assert.NotErrorIs(t, classifyAPIError(&googleapi.Error{Code: http.StatusServiceUnavailable, Message: "down"}), ErrModelOverloaded)
+	assert.NotErrorIs(t, classifyAPIError(&googleapi.Error{Code: http.StatusInternalServerError, Message: "overloaded"}), ErrModelOverloaded)
+	assert.NoError(t, classifyAPIError(nil))
+
+	var classified []error
+	m := &failingEmbeddingModel{failures: 3, err: overloaded}
+	g := newFailingEmbeddingClient(m, WithRetries(2), WithRetryableErrorClassifier(func(err error) bool {
+		classified = append(classified, err)
+		return DefaultRetryableErrorClassifier(err)
+	}))
+	_, err := g.CreateEmbedding(context.Background(), []string{"text-1"})
+	require.ErrorIs(t, err, ErrModelOverloaded)
+	require.ErrorIs(t, err, overloaded)
+	assert.Equal(t, 3, m.calls)
+	require.Len(t, classified, 2)
+	require.ErrorIs(t, classified[0], ErrModelOverloaded)
+}
+
+func TestRetries(t *testing.T) {
+	t.Parallel()
+	unavailable := &googleapi.Error{Code: http.StatusServiceUnavailable}
+	internal := &googleapi.Error{Code: http.StatusInternalServerError}
+
+	// Without WithRetries, calls aren't retried.
+	m := &failingEmbeddingModel{failures: 1, err: unavailable}
+	_, err := newFailingEmbeddingClient(m).CreateEmbedding(context.Background(), []string{"text-1"})
+	require.ErrorIs(t, err, unavailable)
+	assert.Equal(t, 1, m.calls)
+
+	m = &failingEmbeddingModel{failures: 2, err: unavailable}
+	got, err := newFailingEmbeddingClient(m, WithRetries(2)).CreateEmbedding(context.Background(), []string{"text-1"})
+	require.NoError(t, err)
+	assert.Equal(t, [][]float32{{1}}, got)
+	assert.Equal(t, 3, m.calls)
+
+	m = &failingEmbeddingModel{failures: 3, err: unavailable}
+	_, err = newFailingEmbeddingClient(m, WithRetries(2)).CreateEmbedding(context.Background(), []string{"text-1"})
+	require.ErrorIs(t, err, unavailable)
+	assert.Equal(t, 3, m.calls)
+
+	m = &failingEmbeddingModel{failures: 1, err: internal}
+	_, err = newFailingEmbeddingClient(m, WithRetries(2)).CreateEmbedding(context.Background(), []string{"text-1"})
+	require.ErrorIs(t, err, internal)
+	assert.Equal(t, 1, m.calls)
+
+	m = &failingEmbeddingModel{failures: 1, err: internal}
+	retryInternal := func(err error) bool { return errors.Is(err, internal) }
+	_, err = newFailingEmbeddingClient(m, WithRetries(2), WithRetryableErrorClassifier(retryInternal)).
+		CreateEmbedding(context.Background(), []string{"text-1"})
+	require.NoError(t, err)
+	assert.Equal(t, 2, m.calls)
+}
@@ -0,0 +1,78 @@
+package googleai
+
+import (
+	"errors"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/tmc/langchaingo/llms"
+)
+
+// harmThresholdMetadataKey is the llms.CallOptions metadata key under which
+// WithCallHarmThreshold stores the per-call harm threshold.
+const harmThresholdMetadataKey = "googleai.harm_threshold"
+
+// harmCategories are the harm categories Gemini models support safety
+// settings for.
+var harmCategories = []genai.HarmCategory{ //nolint:gochecknoglobals
+	genai.HarmCategoryHarassment,
+	genai.HarmCategoryHateSpeech,
+	genai.HarmCategorySexuallyExplicit,
+	genai.HarmCategoryDangerousContent,
+}
+
+// WithCallHarmThreshold sets the threshold for blocking harmful content for a
+// single GenerateContent call, for all harm categories. It takes precedence
+// over the client's WithHarmThreshold option.
+func WithCallHarmThreshold(threshold genai.HarmBlockThreshold) llms.CallOption {
+	return llms.WithMetadata(harmThresholdMetadataKey, threshold)
+}
+
+// safetySettings returns the safety settings for a call with opts: the
+// settings passed with WithModelParameters if any, otherwise settings for the
+// per-call harm threshold if set, otherwise for the client's harm threshold. It
+// returns nil when none is set, leaving the API's defaults in place.
+func (g *GoogleAI) safetySettings(opts *llms.CallOptions) []*genai.SafetySetting {
+	if params, ok := modelParameters(opts); ok && params.SafetySettings != nil {
+		return params.SafetySettings
+	}
+
+	threshold := g.opts.harmThreshold
+	if t, ok := opts.Metadata[harmThresholdMetadataKey].(genai.HarmBlockThreshold); ok {
+		threshold = t
+	}
+	if threshold == genai.HarmBlockUnspecified {
+		return nil
+	}
+
+	settings := make([]*genai.SafetySetting, 0, len(harmCategories))
+	for _, category := range harmCategories {
+		settings = append(settings, &genai.SafetySetting{
+			Category:  category,
+			Threshold: threshold,
+		})
+	}
+	return settings
+}
+
+// blockedResponse converts err to a response with a single empty choice if it
+// is a *genai.BlockedError and the client was created with
+// WithTreatSafetyBlockAsEmpty. The block reason and safety ratings are reported
+// in GenerationInfo[BLOCK_REASON] and GenerationInfo[SAFETY].
+func (g *GoogleAI) blockedResponse(err error) (*llms.ContentResponse, bool) {
+	var blocked *genai.BlockedError
+	if !g.opts.treatSafetyBlockAsEmpty || !errors.As(err, &blocked) {
+		return nil, false
+	}
+
+	choice := &llms.ContentChoice{GenerationInfo: make(map[string]any)}
+	if blocked.Candidate != nil {
+		choice.StopReason = blocked.Candidate.FinishReason.String()
+		choice.GenerationInfo[BLOCK_REASON] = blocked.Candidate.FinishReason.String()
+		choice.GenerationInfo[SAFETY] = blocked.Candidate.SafetyRatings
+	}
+	if blocked.PromptFeedback != nil {
+		choice.GenerationInfo[BLOCK_REASON] = blocked.PromptFeedback.BlockReason.String()
+		choice.GenerationInfo[SAFETY] = blocked.PromptFeedback.SafetyRatings
+	}
+	return &llms.ContentResponse{Choices: []*llms.ContentChoice{choice}}, true
+}
@@ -0,0 +1,141 @@
+package googleai
+
+import (
+	"context"
+	"io"
+	"net/http"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+const (
+	// TTFT_MS is the GenerationInfo key under which the time to first token of
+	// a streaming call is reported: the milliseconds from requesting the
+	// stream to receiving its first text. It is missing if no text was
+	// received.
+	TTFT_MS = "ttft_ms" //nolint:revive,stylecheck
+	// STREAM_DURATION_MS is the GenerationInfo key under which the duration
+	// of a streaming call is reported: the milliseconds from requesting the
+	// stream to its end.
+	STREAM_DURATION_MS = "stream_duration_ms" //nolint:revive,stylecheck
+)
+
+// discardStreamAggregateMetadataKey is the llms.CallOptions metadata key
+// under which WithDiscardStreamAggregate is stored.
+const discardStreamAggregateMetadataKey = "googleai.discard_stream_aggregate"
+
+// WithDiscardStreamAggregate makes streaming calls pass the response text to
+// the streaming function without also collecting it into the returned
+// response, whose choices then have no content: no text, inline data or
+// citations, just the stop reason and metadata such as safety ratings. This
+// saves holding a copy of huge outputs in memory for consumers that only use
+// the stream, e.g. to write it to a file. The genai version this client is
+// built on still merges the streamed responses internally, so memory use is
+// reduced by this client's copy, not to the size of a chunk.
+func WithDiscardStreamAggregate() llms.CallOption {
+	return llms.WithMetadata(discardStreamAggregateMetadataKey, true)
+}
+
+// discardStreamAggregate reports whether WithDiscardStreamAggregate is set in
+// opts.
+func discardStreamAggregate(opts *llms.CallOptions) bool {
+	discard, _ := opts.Metadata[discardStreamAggregateMetadataKey].(bool)
+	return discard
+}
+
+// streamingFuncNMetadataKey is the llms.CallOptions metadata key under which
+// WithStreamingFuncN stores the streaming function.
+const streamingFuncNMetadataKey = "googleai.streaming_func_n"
+
+// maxCandidates is the maximum number of candidates the API generates.
+const maxCandidates = 8
+
+// WithStreamingFuncN sets a streaming function for streaming multiple
+// candidates, requested with llms.WithN, which is passed the text of each
+// candidate along with the candidate's index. The response has a choice for
+// every candidate, in index order. It replaces llms.WithStreamingFunc, which
+// only supports a single candidate. Chat sessions always generate a single
+// candidate, so multiple candidates are only streamed for a single message.
+func WithStreamingFuncN(streamingFunc func(ctx context.Context, index int, chunk []byte) error) llms.CallOption {
+	return llms.WithMetadata(streamingFuncNMetadataKey, streamingFunc)
+}
+
+// streamingFuncN returns the streaming function set with WithStreamingFuncN,
+// if any.
+func streamingFuncN(opts *llms.CallOptions) func(ctx context.Context, index int, chunk []byte) error {
+	streamingFunc, _ := opts.Metadata[streamingFuncNMetadataKey].(func(ctx context.Context, index int, chunk []byte) error)
+	return streamingFunc
+}
+
+// StreamChunk is a single item sent on the channel returned by StreamContent.
+// Every chunk but the last carries a Text delta; the last chunk carries
+// either the aggregated Response or the Err that ended generation.
+type StreamChunk struct {
+	// Text is the next piece of generated text.
+	Text string
+	// Response is the complete response, set on the final chunk on success.
+	Response *llms.ContentResponse
+	// Err is the error that ended generation, set on the final chunk on failure.
+	Err error
+}
+
+// StreamContent is like GenerateContent with a streaming function, but
+// delivers the generated text on a channel instead. The channel is unbuffered,
+// so generation proceeds only as fast as the caller receives, and it is
+// closed once the final chunk has been sent. If ctx is canceled, generation
+// stops and the channel is closed without further chunks.
+func (g *GoogleAI) StreamContent(ctx context.Context, messages []llms.MessageContent, options ...llms.CallOption) (<-chan StreamChunk, error) {
+	if len(messages) == 0 {
+		return nil, ErrNoMessages
+	}
+	if err := checkLastMessageFromUser(g.withSingleMessageRole(messages)); err != nil {
+		return nil, err
+	}
+
+	ch := make(chan StreamChunk)
+	send := func(chunk StreamChunk) error {
+		select {
+		case ch <- chunk:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	options = append(options, llms.WithStreamingFunc(func(ctx context.Context, chunk []byte) error {
+		return send(StreamChunk{Text: string(chunk)})
+	}))
+
+	go func() {
+		defer close(ch)
+
+		resp, err := g.GenerateContent(ctx, messages, options...)
+		if err != nil {
+			_ = send(StreamChunk{Err: err})
+			return
+		}
+		_ = send(StreamChunk{Response: resp})
+	}()
+
+	return ch, nil
+}
+
+// StreamToWriter is like GenerateContent, but streams the generated text to w
+// as it arrives. If w implements http.Flusher, it is flushed after every
+// chunk, so that e.g. an http.ResponseWriter delivers the text to the client
+// immediately. The complete response is returned once generation is done.
+func (g *GoogleAI) StreamToWriter(ctx context.Context, messages []llms.MessageContent, w io.Writer, options ...llms.CallOption) (*llms.ContentResponse, error) {
+	flusher, _ := w.(http.Flusher)
+
+	options = append(options, llms.WithStreamingFunc(func(ctx context.Context, chunk []byte) error {
+		if _, err := w.Write(chunk); err != nil {
+			return err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	}))
+
+	return g.GenerateContent(ctx, messages, options...)
+}
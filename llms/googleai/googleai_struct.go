@@ -0,0 +1,417 @@
+package googleai
+
+import (
+	"context"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/tmc/langchaingo/jsonschema"
+	"github.com/tmc/langchaingo/llms"
+)
+
+// ErrInvalidStructuredResponse is returned by GenerateStruct when the model's
+// response isn't JSON that can be unmarshaled into the destination.
+var ErrInvalidStructuredResponse = errors.New("response doesn't match the requested structure")
+
+// ErrInvalidDestination is returned by GenerateStruct when the destination
+// isn't a non-nil pointer.
+var ErrInvalidDestination = errors.New("destination must be a non-nil pointer")
+
+// GenerateStruct generates content from messages and unmarshals it into dest,
+// which must be a non-nil pointer. A JSON schema derived from the type of dest
+// is appended to the last message, instructing the model to respond with
+// matching JSON. Struct fields are named after their json tags, and a
+// description tag, if any, is passed to the model as the field's description.
+//
+// The genai version this client is built on doesn't support constraining the
+// response to a schema, so the structure is requested in the prompt; responses
+// that don't conform fail with ErrInvalidStructuredResponse.
+func (g *GoogleAI) GenerateStruct(ctx context.Context, messages []llms.MessageContent, dest any, options ...llms.CallOption) error {
+	v := reflect.ValueOf(dest)
+	if v.Kind() != reflect.Pointer || v.IsNil() {
+		return fmt.Errorf("%w: got %T", ErrInvalidDestination, dest)
+	}
+	if len(messages) == 0 {
+		return ErrNoMessages
+	}
+
+	schema, err := json.Marshal(schemaFor(v.Type().Elem()))
+	if err != nil {
+		return err
+	}
+
+	// Copy the last message so that the caller's parts aren't modified.
+	messages = append([]llms.MessageContent(nil), messages...)
+	last := &messages[len(messages)-1]
+	last.Parts = append(append([]llms.ContentPart(nil), last.Parts...), llms.TextContent{
+		Text: "Respond only with JSON, without any other text, conforming to this JSON schema:\n" + string(schema),
+	})
+
+	resp, err := g.GenerateContent(ctx, messages, options...)
+	if err != nil {
+		return err
+	}
+	if len(resp.Choices) == 0 {
+		return ErrNoContentInResponse
+	}
+
+	opts := g.callOptions(options...)
+	text := resp.Choices[0].Content
+	if repaired, ok := opts.Metadata[repairTruncatedJSONMetadataKey].(*bool); ok && IsTruncated(resp.Choices[0]) {
+		var didRepair bool
+		text, didRepair = repairTruncatedJSON(trimJSONFence(text))
+		if repaired != nil {
+			*repaired = didRepair
+		}
+	}
+	return decodeStructuredResponse(text, dest, &opts)
+}
+
+// repairTruncatedJSONMetadataKey is the llms.CallOptions metadata key under
+// which WithTruncatedJSONRepair stores the repaired flag.
+const repairTruncatedJSONMetadataKey = "googleai.repair_truncated_json"
+
+// WithTruncatedJSONRepair makes GenerateStruct repair JSON responses that were
+// truncated because they reached the maximum number of output tokens, instead
+// of failing to unmarshal them: an unterminated string value is terminated,
+// any other incomplete trailing value is dropped, and open objects and arrays
+// are closed. If repaired isn't nil, it is set to whether the response was
+// repaired. Repaired output is incomplete, and may lack required fields.
+func WithTruncatedJSONRepair(repaired *bool) llms.CallOption {
+	return llms.WithMetadata(repairTruncatedJSONMetadataKey, repaired)
+}
+
+// repairTruncatedJSON makes truncated JSON text valid, as described for
+// WithTruncatedJSONRepair, and reports whether it had to. Text that isn't
+// truncated, or can't be repaired, is returned as is.
+func repairTruncatedJSON(text string) (string, bool) {
+	type frame struct {
+		open      byte
+		expectKey bool
+	}
+	var stack []frame
+	// cut is the last position the text can be cut at, after a complete value
+	// or an opening brace or bracket, and cutStack the containers open there.
+	cut := -1
+	var cutStack []frame
+	mark := func(i int) {
+		cut = i
+		cutStack = append(cutStack[:0], stack...)
+	}
+
+	inString, escaped, isKey := false, false, false
+	// escapeStart is the position of the last escape sequence in the current
+	// string, if any.
+	escapeStart := -1
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped, escapeStart = true, i
+			case c == '"':
+				inString = false
+				if !isKey {
+					mark(i + 1)
+				}
+			}
+			continue
+		}
+		switch c {
+		case '"':
+			inString, escapeStart = true, -1
+			isKey = len(stack) > 0 && stack[len(stack)-1].open == '{' && stack[len(stack)-1].expectKey
+		case '{', '[':
+			stack = append(stack, frame{open: c, expectKey: c == '{'})
+			mark(i + 1)
+		case '}', ']':
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+			mark(i + 1)
+		case ':':
+			if len(stack) > 0 {
+				stack[len(stack)-1].expectKey = false
+			}
+		case ',':
+			// The value before the comma is complete.
+			mark(i)
+			if len(stack) > 0 && stack[len(stack)-1].open == '{' {
+				stack[len(stack)-1].expectKey = true
+			}
+		}
+	}
+	if len(stack) == 0 && !inString {
+		return text, false
+	}
+
+	var repaired string
+	switch {
+	case inString && !isKey:
+		// Terminate the string value, without an incomplete escape sequence.
+		repaired = text
+		if escapeStart >= 0 && (escaped || text[escapeStart+1] == 'u' && len(text)-escapeStart < len(`\u0000`)) {
+			repaired = text[:escapeStart]
+		}
+		repaired += `"`
+	case cut >= 0:
+		repaired, stack = text[:cut], cutStack
+	default:
+		return text, false
+	}
+	var closing strings.Builder
+	for i := len(stack) - 1; i >= 0; i-- {
+		if stack[i].open == '{' {
+			closing.WriteByte('}')
+		} else {
+			closing.WriteByte(']')
+		}
+	}
+	return repaired + closing.String(), true
+}
+
+// structDecoderMetadataKey is the llms.CallOptions metadata key under which
+// WithStructDecoder stores the decoder configuration.
+const structDecoderMetadataKey = "googleai.struct_decoder"
+
+// WithStructDecoder configures the json.Decoder GenerateStruct decodes the
+// response with, e.g. to reject fields the destination doesn't have with
+// (*json.Decoder).DisallowUnknownFields, catching drift between the requested
+// schema and the Go type.
+func WithStructDecoder(configure func(*json.Decoder)) llms.CallOption {
+	return llms.WithMetadata(structDecoderMetadataKey, configure)
+}
+
+// decodeStructuredResponse decodes the JSON in text into dest, with the
+// decoder configured as set with WithStructDecoder in opts. Errors name the
+// offending field where possible.
+func decodeStructuredResponse(text string, dest any, opts *llms.CallOptions) error {
+	dec := json.NewDecoder(strings.NewReader(trimJSONFence(text)))
+	if configure, ok := opts.Metadata[structDecoderMetadataKey].(func(*json.Decoder)); ok {
+		configure(dec)
+	}
+
+	err := dec.Decode(dest)
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &typeErr) && typeErr.Field != "":
+		return fmt.Errorf("%w: field %q: %w", ErrInvalidStructuredResponse, typeErr.Field, err)
+	case err != nil:
+		return fmt.Errorf("%w: %w", ErrInvalidStructuredResponse, err)
+	}
+	// Like json.Unmarshal, reject anything after the value.
+	if _, err := dec.Token(); !errors.Is(err, io.EOF) {
+		return fmt.Errorf("%w: unexpected data after the JSON value", ErrInvalidStructuredResponse)
+	}
+	return nil
+}
+
+// trimJSONFence removes a Markdown code fence around text, which models tend
+// to add around JSON even when asked not to.
+func trimJSONFence(text string) string {
+	text = strings.TrimSpace(text)
+	if !strings.HasPrefix(text, "```") {
+		return text
+	}
+	text = strings.TrimPrefix(text, "```")
+	text = strings.TrimPrefix(text, "json")
+	text = strings.TrimSuffix(text, "```")
+	return strings.TrimSpace(text)
+}
+
+// schemaFor derives a JSON schema from t. Struct fields without omitempty in
+// their json tag are required, and the property ordering of a struct follows
+// its field declaration order, so that the model generates fields in a
+// predictable order. As with encoding/json, the fields of embedded structs
+// are inlined, and types implementing json.Marshaler or
+// encoding.TextMarshaler, like time.Time, are described as strings. Types
+// that contain themselves are described by a plain object or array schema
+// where they recur.
+func schemaFor(t reflect.Type) jsonschema.Definition {
+	return schemaForType(t, make(map[reflect.Type]bool))
+}
+
+// schemaForType is schemaFor, with visiting holding the struct, slice and
+// array types whose schema is being derived further up the recursion.
+func schemaForType(t reflect.Type, visiting map[reflect.Type]bool) jsonschema.Definition {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	// Types marshaling themselves are taken to marshal to strings, as most do.
+	switch {
+	case t == timeType:
+		return jsonschema.Definition{Type: jsonschema.String, Format: "date-time"}
+	case implements(t, jsonMarshalerType), implements(t, textMarshalerType):
+		return jsonschema.Definition{Type: jsonschema.String}
+	}
+
+	switch t.Kind() { //nolint:exhaustive
+	case reflect.Bool:
+		return jsonschema.Definition{Type: jsonschema.Boolean}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return jsonschema.Definition{Type: jsonschema.Integer}
+	case reflect.Float32, reflect.Float64:
+		return jsonschema.Definition{Type: jsonschema.Number}
+	case reflect.String:
+		return jsonschema.Definition{Type: jsonschema.String}
+	case reflect.Slice, reflect.Array:
+		// Like encoding/json, byte slices are base64 strings.
+		if t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8 {
+			return jsonschema.Definition{Type: jsonschema.String}
+		}
+		if visiting[t] {
+			return jsonschema.Definition{Type: jsonschema.Array}
+		}
+		visiting[t] = true
+		defer delete(visiting, t)
+		items := schemaForType(t.Elem(), visiting)
+		return jsonschema.Definition{Type: jsonschema.Array, Items: &items}
+	case reflect.Map:
+		return jsonschema.Definition{Type: jsonschema.Object}
+	case reflect.Struct:
+		if visiting[t] {
+			return jsonschema.Definition{Type: jsonschema.Object}
+		}
+		visiting[t] = true
+		defer delete(visiting, t)
+		def := jsonschema.Definition{Type: jsonschema.Object, Properties: make(map[string]jsonschema.Definition)}
+		for _, field := range structSchemaFields(t, visiting) {
+			prop := schemaForType(field.Type, visiting)
+			prop.Description = field.Tag.Get("description")
+			def.Properties[field.name] = prop
+			def.PropertyOrdering = append(def.PropertyOrdering, field.name)
+			if !field.omitEmpty {
+				def.Required = append(def.Required, field.name)
+			}
+		}
+		return def
+	default:
+		return jsonschema.Definition{}
+	}
+}
+
+//nolint:gochecknoglobals
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// implements reports whether t or a pointer to it implements iface.
+func implements(t, iface reflect.Type) bool {
+	return t.Implements(iface) || reflect.PointerTo(t).Implements(iface)
+}
+
+// schemaField is a struct field as encoded by encoding/json.
+type schemaField struct {
+	reflect.StructField
+	// name is the JSON name of the field.
+	name      string
+	omitEmpty bool
+	tagged    bool
+	// depth is the number of embedded structs the field is promoted from.
+	depth int
+}
+
+// structSchemaFields returns the fields of struct type t as encoded by
+// encoding/json, in declaration order: the fields of embedded structs without
+// a JSON name are inlined, and of fields with the same name, the least nested
+// one wins, or none if that's ambiguous.
+func structSchemaFields(t reflect.Type, visiting map[reflect.Type]bool) []schemaField {
+	var all []schemaField
+	var collect func(t reflect.Type, depth int)
+	collect = func(t reflect.Type, depth int) {
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name, opts, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if name == "-" && opts == "" {
+				continue
+			}
+			if field.Anonymous && name == "" {
+				embedded := field.Type
+				if embedded.Kind() == reflect.Pointer {
+					if !field.IsExported() {
+						// encoding/json can't set these.
+						continue
+					}
+					embedded = embedded.Elem()
+				}
+				if embedded.Kind() == reflect.Struct {
+					if !visiting[embedded] {
+						visiting[embedded] = true
+						collect(embedded, depth+1)
+						delete(visiting, embedded)
+					}
+					continue
+				}
+			}
+			if !field.IsExported() {
+				continue
+			}
+			tagged := name != ""
+			if !tagged {
+				name = field.Name
+			}
+			all = append(all, schemaField{
+				StructField: field,
+				name:        name,
+				omitEmpty:   strings.Contains(","+opts+",", ",omitempty,"),
+				tagged:      tagged,
+				depth:       depth,
+			})
+		}
+	}
+	collect(t, 0)
+
+	fields := make([]schemaField, 0, len(all))
+	seen := make(map[string]bool)
+	for _, field := range all {
+		if seen[field.name] {
+			continue
+		}
+		seen[field.name] = true
+		if dominant, ok := dominantField(all, field.name); ok {
+			fields = append(fields, dominant)
+		}
+	}
+	return fields
+}
+
+// dominantField returns the field named name that encoding/json encodes: the
+// least nested one, preferring a tagged one at the same depth.
+func dominantField(fields []schemaField, name string) (schemaField, bool) {
+	var candidates []schemaField
+	for _, field := range fields {
+		switch {
+		case field.name != name:
+		case len(candidates) == 0 || field.depth < candidates[0].depth:
+			candidates = []schemaField{field}
+		case field.depth == candidates[0].depth:
+			candidates = append(candidates, field)
+		}
+	}
+	var tagged []schemaField
+	for _, field := range candidates {
+		if field.tagged {
+			tagged = append(tagged, field)
+		}
+	}
+	switch {
+	case len(tagged) == 1:
+		return tagged[0], true
+	case len(tagged) == 0 && len(candidates) == 1:
+		return candidates[0], true
+	default:
+		return schemaField{}, false
+	}
+}
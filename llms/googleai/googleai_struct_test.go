@@ -0,0 +1,238 @@
+package googleai
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tmc/langchaingo/jsonschema"
+	"github.com/tmc/langchaingo/llms"
+	"github.com/tmc/langchaingo/schema"
+)
+
+func TestSchemaFor(t *testing.T) {
+	t.Parallel()
+
+	type city struct {
+		Name       string   `json:"name" description:"The name of the city"`
+		Population int      `json:"population,omitempty"`
+		Area       *float64 `json:"area"`
+		Landmarks  []string `json:"landmarks"`
+		Internal   string   `json:"-"`
+		unexported string
+	}
+
+	assert.Equal(t, jsonschema.Definition{
+		Type: jsonschema.Object,
+		Properties: map[string]jsonschema.Definition{
+			"name":       {Type: jsonschema.String, Description: "The name of the city"},
+			"population": {Type: jsonschema.Integer},
+			"area":       {Type: jsonschema.Number},
+			"landmarks":  {Type: jsonschema.Array, Items: &jsonschema.Definition{Type: jsonschema.String}},
+		},
+		Required:         []string{"name", "area", "landmarks"},
+		PropertyOrdering: []string{"name", "population", "area", "landmarks"},
+	}, schemaFor(reflect.TypeOf(city{})))
+}
+
+func TestSchemaForPropertyOrdering(t *testing.T) {
+	t.Parallel()
+
+	type point struct {
+		Y int `json:"y"`
+		X int `json:"x"`
+		Z int `json:"z"`
+	}
+
+	schema, err := json.Marshal(schemaFor(reflect.TypeOf(point{})))
+	require.NoError(t, err)
+	assert.Contains(t, string(schema), `"propertyOrdering":["y","x","z"]`)
+}
+
+func TestSchemaForRecursiveAndByteTypes(t *testing.T) {
+	t.Parallel()
+
+	type node struct {
+		Name     string  `json:"name"`
+		Children []node  `json:"children"`
+		Parent   *node   `json:"parent,omitempty"`
+		Data     []byte  `json:"data"`
+		Hash     [2]byte `json:"hash"`
+	}
+
+	assert.Equal(t, jsonschema.Definition{
+		Type: jsonschema.Object,
+		Properties: map[string]jsonschema.Definition{
+			"name":     {Type: jsonschema.String},
+			"children": {Type: jsonschema.Array, Items: &jsonschema.Definition{Type: jsonschema.Object}},
+			"parent":   {Type: jsonschema.Object},
+			"data":     {Type: jsonschema.String},
+			"hash":     {Type: jsonschema.Array, Items: &jsonschema.Definition{Type: jsonschema.Integer}},
+		},
+		Required:         []string{"name", "children", "data", "hash"},
+		PropertyOrdering: []string{"name", "children", "parent", "data", "hash"},
+	}, schemaFor(reflect.TypeOf(node{})))
+}
+
+func TestSchemaForMarshalersAndEmbeddedStructs(t *testing.T) {
+	t.Parallel()
+
+	type Audit struct {
+		Created time.Time `json:"created"`
+		Updated time.Time `json:"updated,omitempty"`
+		Name    string    `json:"name"`
+	}
+	type event struct {
+		Audit
+		Name   string          `json:"name"`
+		Addr   netip.Addr      `json:"addr"`
+		Raw    json.RawMessage `json:"raw"`
+		Nested Audit           `json:"nested"`
+	}
+
+	def := schemaFor(reflect.TypeOf(event{}))
+	assert.Equal(t, []string{"created", "updated", "name", "addr", "raw", "nested"}, def.PropertyOrdering)
+	assert.Equal(t, []string{"created", "name", "addr", "raw", "nested"}, def.Required)
+	assert.Equal(t, jsonschema.Definition{Type: jsonschema.String, Format: "date-time"}, def.Properties["created"])
+	assert.Equal(t, jsonschema.Definition{Type: jsonschema.String}, def.Properties["name"])
+	assert.Equal(t, jsonschema.Definition{Type: jsonschema.String}, def.Properties["addr"])
+	assert.Equal(t, jsonschema.Definition{Type: jsonschema.String}, def.Properties["raw"])
+	assert.Equal(t, jsonschema.Object, def.Properties["nested"].Type)
+
+	// A response conforming to the schema unmarshals into the type.
+	var dest event
+	require.NoError(t, json.Unmarshal([]byte(`{"created": "2024-01-02T03:04:05Z", "name": "launch", "addr": "192.0.2.1", "raw": "x", "nested": {"created": "2024-01-02T03:04:05Z", "name": "n"}}`), &dest))
+	assert.Equal(t, 2024, dest.Created.Year())
+	assert.Equal(t, "launch", dest.Name)
+}
+
+func TestTrimJSONFence(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, `{"a": 1}`, trimJSONFence(`{"a": 1}`))
+	assert.Equal(t, `{"a": 1}`, trimJSONFence("```json\n{\"a\": 1}\n```\n"))
+	assert.Equal(t, `{"a": 1}`, trimJSONFence("```\n{\"a\": 1}\n```"))
+}
+
+func TestGenerateStructInvalidDestination(t *testing.T) {
+	t.Parallel()
+	g := &GoogleAI{opts: defaultOptions()}
+
+	var dest struct{}
+	err := g.GenerateStruct(context.Background(), []llms.MessageContent{{
+		Role:  schema.ChatMessageTypeHuman,
+		Parts: []llms.ContentPart{llms.TextContent{Text: "Name a city"}},
+	}}, dest)
+	require.ErrorIs(t, err, ErrInvalidDestination)
+}
+
+func TestDecodeStructuredResponse(t *testing.T) {
+	t.Parallel()
+
+	type city struct {
+		Name       string `json:"name"`
+		Population int    `json:"population"`
+	}
+	strict := llms.CallOptions{}
+	WithStructDecoder(func(dec *json.Decoder) { dec.DisallowUnknownFields() })(&strict)
+
+	var dest city
+	require.NoError(t, decodeStructuredResponse(`{"name": "Paris", "country": "France"}`, &dest, &llms.CallOptions{}))
+	assert.Equal(t, city{Name: "Paris"}, dest)
+
+	err := decodeStructuredResponse(`{"name": "Paris", "country": "France"}`, &dest, &strict)
+	require.ErrorIs(t, err, ErrInvalidStructuredResponse)
+	assert.Contains(t, err.Error(), `"country"`)
+
+	err = decodeStructuredResponse(`{"name": "Paris", "population": "many"}`, &dest, &strict)
+	require.ErrorIs(t, err, ErrInvalidStructuredResponse)
+	assert.Contains(t, err.Error(), `field "population"`)
+
+	err = decodeStructuredResponse(`{"name": "Paris"} and more`, &dest, &llms.CallOptions{})
+	require.ErrorIs(t, err, ErrInvalidStructuredResponse)
+}
+
+func TestGenerateStruct(t *testing.T) {
+	t.Parallel()
+	llm := newClient(t)
+
+	var city struct {
+		Name    string `json:"name"`
+		Country string `json:"country"`
+	}
+	err := llm.GenerateStruct(context.Background(), []llms.MessageContent{{
+		Role:  schema.ChatMessageTypeHuman,
+		Parts: []llms.ContentPart{llms.TextContent{Text: "What is the capital of France?"}},
+	}}, &city)
+	require.NoError(t, err)
+	assert.Equal(t, "Paris", city.Name)
+}
+
+func TestRepairTruncatedJSON(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		text, want string
+		repaired   bool
+	}{
+		{`{"name": "Paris"}`, `{"name": "Paris"}`, false},
+		{`{"name": "Par`, `{"name": "Par"}`, true},
+		{`{"name": "Paris", "pop`, `{"name": "Paris"}`, true},
+		{`{"name": "Paris", "population": 21`, `{"name": "Paris"}`, true},
+		{`{"name": "Paris", "population": 2148000,`, `{"name": "Paris", "population": 2148000}`, true},
+		{`{"cities": [{"name": "Paris"}, {"name": "Lyon"`, `{"cities": [{"name": "Paris"}, {"name": "Lyon"}]}`, true},
+		{`{"cities": [`, `{"cities": []}`, true},
+		{`[1, 2, 3`, `[1, 2]`, true},
+		{`{"quote": "say \"hi\`, `{"quote": "say \"hi"}`, true},
+		{`{"name": "Caf\u00`, `{"name": "Caf"}`, true},
+		{`{"name": "Café`, `{"name": "Café"}`, true},
+		{`{"a": "}]", "b": [`, `{"a": "}]", "b": []}`, true},
+		{`tru`, `tru`, false},
+	}
+	for _, tt := range tests {
+		got, repaired := repairTruncatedJSON(tt.text)
+		assert.Equal(t, tt.want, got, tt.text)
+		assert.Equal(t, tt.repaired, repaired, tt.text)
+		if repaired {
+			assert.True(t, json.Valid([]byte(got)), got)
+		}
+	}
+}
+
+func TestGenerateStructTruncatedJSONRepair(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		text, _ := json.Marshal("```json\n" + `{"name": "Paris", "landmarks": ["Eiffel Tower", "Lou`)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"candidates": [{"content": {"role": "model", "parts": [{"text": ` + string(text) + `}]}, "finishReason": "MAX_TOKENS"}]}`))
+	}))
+	defer srv.Close()
+
+	g := newFakeAPIClient(t, srv.URL)
+	messages := []llms.MessageContent{{
+		Role:  schema.ChatMessageTypeHuman,
+		Parts: []llms.ContentPart{llms.TextContent{Text: "Describe Paris"}},
+	}}
+	type city struct {
+		Name      string   `json:"name"`
+		Landmarks []string `json:"landmarks"`
+	}
+
+	var dest city
+	err := g.GenerateStruct(context.Background(), messages, &dest)
+	require.ErrorIs(t, err, ErrInvalidStructuredResponse)
+
+	var repaired bool
+	err = g.GenerateStruct(context.Background(), messages, &dest, WithTruncatedJSONRepair(&repaired))
+	require.NoError(t, err)
+	assert.True(t, repaired)
+	assert.Equal(t, city{Name: "Paris", Landmarks: []string{"Eiffel Tower", "Lou"}}, dest)
+}
@@ -0,0 +1,77 @@
+package googleai
+
+import (
+	"context"
+
+	"github.com/tmc/langchaingo/llms"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// OUTPUT_TOKENS is the GenerationInfo key under which the number of tokens of
+// a choice is reported, as an int, when the API reports it. Traced calls
+// record their sum as the gen_ai.usage.output_tokens span attribute.
+const OUTPUT_TOKENS = "output_tokens" //nolint:revive,stylecheck
+
+// tracerName is the name of the OpenTelemetry tracer spans are created with.
+const tracerName = "github.com/tmc/langchaingo/llms/googleai"
+
+// Span attribute keys, following the OpenTelemetry semantic conventions for
+// generative AI.
+const (
+	attrSystem        = attribute.Key("gen_ai.system")
+	attrRequestModel  = attribute.Key("gen_ai.request.model")
+	attrFinishReasons = attribute.Key("gen_ai.response.finish_reasons")
+	attrOutputTokens  = attribute.Key("gen_ai.usage.output_tokens")
+	attrInputCount    = attribute.Key("googleai.embedding.input_count")
+)
+
+// startSpan starts a span named name for a call to model, if tracing is
+// enabled with WithTracerProvider. Otherwise the returned span does nothing.
+func (g *GoogleAI) startSpan(ctx context.Context, name, model string) (context.Context, trace.Span) {
+	if g.opts.tracerProvider == nil {
+		return ctx, noop.Span{}
+	}
+	return g.opts.tracerProvider.Tracer(tracerName).Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrSystem.String("google_ai"), attrRequestModel.String(model)))
+}
+
+// endSpan ends span, recording err, if any, as the outcome of the call.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// finishReasons returns the stop reasons of the choices of resp.
+func finishReasons(resp *llms.ContentResponse) []string {
+	if resp == nil {
+		return nil
+	}
+	reasons := make([]string, 0, len(resp.Choices))
+	for _, choice := range resp.Choices {
+		reasons = append(reasons, choice.StopReason)
+	}
+	return reasons
+}
+
+// outputTokens returns the number of tokens of the choices of resp, and
+// whether the API reported it for any of them.
+func outputTokens(resp *llms.ContentResponse) (int, bool) {
+	if resp == nil {
+		return 0, false
+	}
+	total, reported := 0, false
+	for _, choice := range resp.Choices {
+		if tokens, ok := choice.GenerationInfo[OUTPUT_TOKENS].(int); ok {
+			total += tokens
+			reported = true
+		}
+	}
+	return total, reported
+}
@@ -0,0 +1,102 @@
+package googleai
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tmc/langchaingo/llms"
+	"github.com/tmc/langchaingo/schema"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// recordingTracerProvider records the spans started with its tracers.
+type recordingTracerProvider struct {
+	noop.TracerProvider
+	spans []*recordingSpan
+}
+
+func (tp *recordingTracerProvider) Tracer(string, ...trace.TracerOption) trace.Tracer {
+	return recordingTracer{tp: tp}
+}
+
+type recordingTracer struct {
+	noop.Tracer
+	tp *recordingTracerProvider
+}
+
+func (t recordingTracer) Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	span := &recordingSpan{name: name, attributes: map[attribute.Key]attribute.Value{}}
+	config := trace.NewSpanStartConfig(opts...)
+	span.SetAttributes(config.Attributes()...)
+	t.tp.spans = append(t.tp.spans, span)
+	return ctx, span
+}
+
+type recordingSpan struct {
+	noop.Span
+	name       string
+	attributes map[attribute.Key]attribute.Value
+	status     codes.Code
+	ended      bool
+}
+
+func (s *recordingSpan) SetAttributes(kv ...attribute.KeyValue) {
+	for _, attr := range kv {
+		s.attributes[attr.Key] = attr.Value
+	}
+}
+
+func (s *recordingSpan) SetStatus(code codes.Code, _ string) { s.status = code }
+
+func (s *recordingSpan) End(...trace.SpanEndOption) { s.ended = true }
+
+func TestTracing(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"candidates": [
+			{"content": {"role": "model", "parts": [{"text": "Hi"}]}, "finishReason": "STOP", "tokenCount": 2},
+			{"content": {"role": "model", "parts": [{"text": "Hello"}]}, "finishReason": "STOP", "tokenCount": 3}
+		]}`))
+	}))
+	defer srv.Close()
+
+	tp := &recordingTracerProvider{}
+	g := newFakeAPIClient(t, srv.URL, WithTracerProvider(tp))
+	_, err := g.GenerateContent(context.Background(), []llms.MessageContent{{
+		Role:  schema.ChatMessageTypeHuman,
+		Parts: []llms.ContentPart{llms.TextContent{Text: "Hello"}},
+	}})
+	require.NoError(t, err)
+
+	require.Len(t, tp.spans, 1)
+	span := tp.spans[0]
+	assert.Equal(t, "googleai.GenerateContent", span.name)
+	assert.True(t, span.ended)
+	assert.Equal(t, codes.Unset, span.status)
+	assert.Equal(t, "gemini-pro", span.attributes[attrRequestModel].AsString())
+	assert.Equal(t, []string{"FinishReasonStop", "FinishReasonStop"}, span.attributes[attrFinishReasons].AsStringSlice())
+	assert.Equal(t, int64(5), span.attributes[attrOutputTokens].AsInt64())
+
+	m := &failingEmbeddingModel{failures: 1, err: errors.New("embedding failed")}
+	g = newFailingEmbeddingClient(m, WithTracerProvider(tp))
+	_, err = g.CreateEmbedding(context.Background(), []string{"text-1", "text-2"})
+	require.Error(t, err)
+
+	require.Len(t, tp.spans, 2)
+	span = tp.spans[1]
+	assert.Equal(t, "googleai.CreateEmbedding", span.name)
+	assert.Equal(t, "embedding-001", span.attributes[attrRequestModel].AsString())
+	assert.Equal(t, int64(2), span.attributes[attrInputCount].AsInt64())
+	assert.Equal(t, codes.Error, span.status)
+	assert.True(t, span.ended)
+}
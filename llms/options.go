@@ -44,6 +44,10 @@ type CallOptions struct {
 	// If a specific function should be invoked, use the format:
 	// `{"name": "my_function"}`
 	FunctionCallBehavior FunctionCallBehavior `json:"function_call"`
+
+	// Metadata is a map of provider-specific options. Providers document the
+	// keys they use and usually offer their own CallOptions to set them.
+	Metadata map[string]any `json:"metadata,omitempty"`
 }
 
 // FunctionDefinition is a definition of a function that can be called by the model.
@@ -184,3 +188,13 @@ func WithFunctions(functions []FunctionDefinition) CallOption {
 		o.Functions = functions
 	}
 }
+
+// WithMetadata will add an option to set a provider-specific metadata value.
+func WithMetadata(key string, value any) CallOption {
+	return func(o *CallOptions) {
+		if o.Metadata == nil {
+			o.Metadata = make(map[string]any)
+		}
+		o.Metadata[key] = value
+	}
+}